@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kbinani/screenshot"
+)
+
+// Capturer abstrait la source des images : écran réel, mire synthétique ou
+// relecture d'une séquence d'images (utile en CI sans affichage).
+type Capturer interface {
+	NumDisplays() int
+	DisplayBounds(index int) image.Rectangle
+	CaptureRect(rect image.Rectangle) (*image.RGBA, error)
+}
+
+func newCapturer(kind, syntheticDisplays, replayDir string) (Capturer, error) {
+	switch kind {
+	case "", "screenshot":
+		return screenshotCapturer{}, nil
+	case "synthetic":
+		return newSyntheticCapturer(syntheticDisplays)
+	case "replay":
+		return newReplayCapturer(replayDir)
+	default:
+		return nil, fmt.Errorf("backend de capture inconnu: %s (screenshot, synthetic, replay)", kind)
+	}
+}
+
+// desktopBounds renvoie l'union de tous les écrans.
+func desktopBounds(c Capturer) image.Rectangle {
+	var bounds image.Rectangle
+	for i := 0; i < c.NumDisplays(); i++ {
+		bounds = bounds.Union(c.DisplayBounds(i))
+	}
+	return bounds
+}
+
+type screenshotCapturer struct{}
+
+func (screenshotCapturer) NumDisplays() int {
+	return screenshot.NumActiveDisplays()
+}
+
+func (screenshotCapturer) DisplayBounds(index int) image.Rectangle {
+	return screenshot.GetDisplayBounds(index)
+}
+
+func (screenshotCapturer) CaptureRect(rect image.Rectangle) (*image.RGBA, error) {
+	return screenshot.CaptureRect(rect)
+}
+
+// syntheticCapturer génère une mire animée : barres de couleur fixes et un
+// carré qui se déplace, pour que chaque frame diffère de la précédente.
+type syntheticCapturer struct {
+	displays []image.Rectangle
+	start    time.Time
+}
+
+func newSyntheticCapturer(spec string) (*syntheticCapturer, error) {
+	if spec == "" {
+		spec = "1920x1080"
+	}
+
+	c := &syntheticCapturer{start: time.Now()}
+	x := 0
+	for _, part := range strings.Split(spec, ",") {
+		var w, h int
+		if n, _ := fmt.Sscanf(strings.TrimSpace(part), "%dx%d", &w, &h); n != 2 || w <= 0 || h <= 0 {
+			return nil, fmt.Errorf("écran synthétique invalide: %q (attendu LxH)", part)
+		}
+		c.displays = append(c.displays, image.Rect(x, 0, x+w, h))
+		x += w
+	}
+	return c, nil
+}
+
+func (c *syntheticCapturer) NumDisplays() int {
+	return len(c.displays)
+}
+
+func (c *syntheticCapturer) DisplayBounds(index int) image.Rectangle {
+	if index < 0 || index >= len(c.displays) {
+		return image.Rectangle{}
+	}
+	return c.displays[index]
+}
+
+var syntheticBars = []color.RGBA{
+	{192, 192, 192, 255},
+	{192, 192, 0, 255},
+	{0, 192, 192, 255},
+	{0, 192, 0, 255},
+	{192, 0, 192, 255},
+	{192, 0, 0, 255},
+	{0, 0, 192, 255},
+}
+
+func (c *syntheticCapturer) CaptureRect(rect image.Rectangle) (*image.RGBA, error) {
+	if rect.Empty() {
+		return nil, fmt.Errorf("zone de capture vide")
+	}
+
+	img := image.NewRGBA(rect)
+	for _, d := range c.displays {
+		area := d.Intersect(rect)
+		if area.Empty() {
+			continue
+		}
+		barWidth := (d.Dx() + len(syntheticBars) - 1) / len(syntheticBars)
+		for y := area.Min.Y; y < area.Max.Y; y++ {
+			for x := area.Min.X; x < area.Max.X; x++ {
+				img.SetRGBA(x, y, syntheticBars[(x-d.Min.X)/barWidth])
+			}
+		}
+
+		// Carré mobile : fait un aller-retour horizontal en 4 secondes
+		size := d.Dy() / 8
+		travel := d.Dx() - size
+		if size <= 0 || travel <= 0 {
+			continue
+		}
+		phase := int(time.Since(c.start).Milliseconds()%4000) * 2 * travel / 4000
+		if phase > travel {
+			phase = 2*travel - phase
+		}
+		box := image.Rect(0, 0, size, size).Add(image.Pt(d.Min.X+phase, d.Min.Y+(d.Dy()-size)/2))
+		draw.Draw(img, box.Intersect(rect), image.White, image.Point{}, draw.Src)
+	}
+	return img, nil
+}
+
+// replayCapturer rejoue en boucle les images d'un dossier (png, jpeg, gif),
+// triées par nom. Chaque capture avance d'une image.
+type replayCapturer struct {
+	mu     sync.Mutex
+	frames []*image.RGBA
+	next   int
+}
+
+func newReplayCapturer(dir string) (*replayCapturer, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("-replay-dir requis pour le backend replay")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("lecture dossier replay: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".png", ".jpg", ".jpeg", ".gif":
+			if !entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+
+	c := &replayCapturer{}
+	for _, name := range names {
+		frame, err := loadReplayFrame(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if len(c.frames) > 0 && frame.Bounds() != c.frames[0].Bounds() {
+			return nil, fmt.Errorf("%s: taille %v différente de la première image %v", name, frame.Bounds().Size(), c.frames[0].Bounds().Size())
+		}
+		c.frames = append(c.frames, frame)
+	}
+	if len(c.frames) == 0 {
+		return nil, fmt.Errorf("aucune image trouvée dans %s", dir)
+	}
+	return c, nil
+}
+
+func loadReplayFrame(path string) (*image.RGBA, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	frame := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(frame, frame.Bounds(), src, src.Bounds().Min, draw.Src)
+	return frame, nil
+}
+
+func (c *replayCapturer) NumDisplays() int {
+	return 1
+}
+
+func (c *replayCapturer) DisplayBounds(index int) image.Rectangle {
+	if index != 0 {
+		return image.Rectangle{}
+	}
+	return c.frames[0].Bounds()
+}
+
+func (c *replayCapturer) CaptureRect(rect image.Rectangle) (*image.RGBA, error) {
+	if rect.Empty() {
+		return nil, fmt.Errorf("zone de capture vide")
+	}
+
+	c.mu.Lock()
+	frame := c.frames[c.next]
+	c.next = (c.next + 1) % len(c.frames)
+	c.mu.Unlock()
+
+	img := image.NewRGBA(rect)
+	draw.Draw(img, rect, frame, rect.Min, draw.Src)
+	return img, nil
+}
+
+// captureCache partage les captures entre clients qui regardent le même
+// écran : une capture récente est réutilisée au lieu d'en refaire une.
+type captureCache struct {
+	capture func(screenIndex int) (*image.RGBA, error)
+
+	mu      sync.Mutex
+	screens map[int]*cachedCapture
+}
+
+type cachedCapture struct {
+	mu  sync.Mutex
+	img *image.RGBA
+	at  time.Time
+}
+
+func newCaptureCache(capture func(screenIndex int) (*image.RGBA, error)) *captureCache {
+	return &captureCache{capture: capture, screens: make(map[int]*cachedCapture)}
+}
+
+// get renvoie une capture de l'écran datant de moins de maxAge. Les appels
+// concurrents sur un même écran attendent la capture en cours.
+func (cc *captureCache) get(screenIndex int, maxAge time.Duration) (*image.RGBA, error) {
+	cc.mu.Lock()
+	entry, ok := cc.screens[screenIndex]
+	if !ok {
+		entry = &cachedCapture{}
+		cc.screens[screenIndex] = entry
+	}
+	cc.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.img != nil && time.Since(entry.at) < maxAge {
+		return entry.img, nil
+	}
+
+	img, err := cc.capture(screenIndex)
+	if err != nil {
+		return nil, err
+	}
+	entry.img, entry.at = img, time.Now()
+	return img, nil
+}
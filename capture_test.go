@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+// testCapture capture un écran de la mire synthétique et l'encode comme le
+// ferait startStreaming pour un client.
+func testCapture(t *testing.T, s *ScreenStreamer, c *client, img *image.RGBA) outFrame {
+	t.Helper()
+	if err := s.sendFrame(c, img, 80); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case f := <-c.frames:
+		return f
+	default:
+		t.Fatal("aucune frame en file")
+		return outFrame{}
+	}
+}
+
+// meanDiff renvoie l'écart moyen par canal entre want, lu à partir de son
+// origine, et got, lu à partir de (0, 0) : le JPEG n'est pas exact.
+func meanDiff(want *image.RGBA, got image.Image) float64 {
+	b := want.Bounds()
+	var sum, n float64
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			w := want.RGBAAt(b.Min.X+x, b.Min.Y+y)
+			r, g, bl, _ := got.At(got.Bounds().Min.X+x, got.Bounds().Min.Y+y).RGBA()
+			for _, d := range []int{int(w.R) - int(r>>8), int(w.G) - int(g>>8), int(w.B) - int(bl>>8)} {
+				if d < 0 {
+					d = -d
+				}
+				sum += float64(d)
+			}
+			n += 3
+		}
+	}
+	return sum / n
+}
+
+func TestCaptureEncodeJPEG(t *testing.T) {
+	capturer, err := newSyntheticCapturer("640x480,320x240")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		screen     int
+		frameScale float64
+		want       image.Point
+	}{
+		{-1, 1, image.Pt(960, 480)},
+		{0, 1, image.Pt(640, 480)},
+		{1, 1, image.Pt(320, 240)},
+		{-1, 0.5, image.Pt(480, 240)},
+	}
+	for _, tt := range tests {
+		s := NewScreenStreamer(capturer)
+		s.geometry.frameScale = tt.frameScale
+		img, err := s.captureScreen(tt.screen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.Bounds().Size(); got != tt.want {
+			t.Errorf("écran %d, échelle %v: capture %v, attendu %v", tt.screen, tt.frameScale, got, tt.want)
+		}
+
+		f := testCapture(t, s, newClient(nil, 0), img)
+		if f.delta || len(f.data) == 0 || f.data[0] != 0xFF {
+			t.Fatalf("écran %d: frame JPEG attendue (delta=%v)", tt.screen, f.delta)
+		}
+		decoded, err := jpeg.Decode(bytes.NewReader(f.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decoded.Bounds().Size(); got != tt.want {
+			t.Errorf("écran %d, échelle %v: JPEG %v, attendu %v", tt.screen, tt.frameScale, got, tt.want)
+		}
+		if d := meanDiff(img, decoded); d > 4 {
+			t.Errorf("écran %d, échelle %v: écart moyen %.1f après décodage", tt.screen, tt.frameScale, d)
+		}
+	}
+}
+
+// Keyframe puis delta, recomposés comme le fait le navigateur.
+func TestCaptureEncodeTiles(t *testing.T) {
+	capturer, err := newSyntheticCapturer("640x480,320x240")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewScreenStreamer(capturer)
+	c := newClient(nil, 0)
+	c.delta.enabled.Store(true)
+
+	img, err := s.captureScreen(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := img.Bounds()
+
+	f := testCapture(t, s, c, img)
+	if !f.delta || f.data[0] != frameKeyframe {
+		t.Fatalf("keyframe attendue, type %d", f.data[0])
+	}
+	var size [2]uint16
+	binary.Read(bytes.NewReader(f.data[1:5]), binary.BigEndian, &size)
+	if int(size[0]) != bounds.Dx() || int(size[1]) != bounds.Dy() {
+		t.Errorf("keyframe %dx%d, attendu %v", size[0], size[1], bounds.Size())
+	}
+	keyframe, err := jpeg.Decode(bytes.NewReader(f.data[5:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(canvas, canvas.Bounds(), keyframe, keyframe.Bounds().Min, draw.Src)
+
+	// Image identique : rien à envoyer
+	if err := s.sendFrame(c, img, 80); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.frames) != 0 {
+		t.Error("frame envoyée alors que rien n'a changé")
+	}
+
+	next := image.NewRGBA(bounds)
+	draw.Draw(next, bounds, img, bounds.Min, draw.Src)
+	changed := image.Rect(100, 50, 180, 90).Add(bounds.Min)
+	draw.Draw(next, changed, image.Black, image.Point{}, draw.Src)
+
+	f = testCapture(t, s, c, next)
+	if !f.delta || f.data[0] != frameDelta {
+		t.Fatalf("delta attendu, type %d", f.data[0])
+	}
+	r := bytes.NewReader(f.data[1:])
+	var header [3]uint16
+	binary.Read(r, binary.BigEndian, &header)
+	if header[2] == 0 {
+		t.Fatal("delta sans tuile")
+	}
+	for i := 0; i < int(header[2]); i++ {
+		var rect [4]uint16
+		var length uint32
+		binary.Read(r, binary.BigEndian, &rect)
+		binary.Read(r, binary.BigEndian, &length)
+		data := make([]byte, length)
+		if _, err := r.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		tile, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("tuile %d: %v", i, err)
+		}
+		at := image.Rect(int(rect[0]), int(rect[1]), int(rect[0]+rect[2]), int(rect[1]+rect[3]))
+		if !at.Overlaps(changed.Sub(bounds.Min)) {
+			t.Errorf("tuile %v hors de la zone modifiée %v", at, changed.Sub(bounds.Min))
+		}
+		draw.Draw(canvas, at, tile, tile.Bounds().Min, draw.Src)
+	}
+	if r.Len() != 0 {
+		t.Errorf("%d octet(s) en trop après les tuiles", r.Len())
+	}
+	if d := meanDiff(next, canvas); d > 4 {
+		t.Errorf("écart moyen %.1f après recomposition", d)
+	}
+}
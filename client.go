@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Nombre de frames en attente par client : au-delà, la plus ancienne est
+	// abandonnée pour qu'un navigateur lent ne ralentisse pas les autres.
+	clientQueueSize    = 3
+	clientControlQueue = 32
+	clientWriteTimeout = 10 * time.Second
+)
+
+type outFrame struct {
+	data []byte
+	// Frame du mode tiles, keyframe comprise : l'encodeur considère déjà
+	// qu'elle a été reçue, sa perte impose une nouvelle keyframe
+	delta bool
+}
+
+// client représente une session websocket. Seule writePump écrit sur conn :
+// gorilla/websocket n'accepte qu'un écrivain concurrent par connexion.
+type client struct {
+	id        uint64
+	conn      *websocket.Conn
+	delta     *deltaEncoder
+	frames    chan outFrame
+	control   chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	connected time.Time
+
+	// État de visualisation propre à chaque client
+	screen     atomic.Int32
+	fps        atomic.Int32
+	quality    atomic.Int32 // 0 = automatique selon le FPS
+	fpsChanged chan int
+	refresh    chan struct{}
+	protocol   atomic.Int32
+
+	// Dernier message "control" reçu, au moment de sa lecture
+	controlEnabled atomic.Bool
+
+	// Utilisé uniquement par la goroutine de lecture
+	legacyWarned       bool
+	limiter            *rateLimiter
+	lastThrottleReport time.Time
+
+	// Événements d'entrée en attente d'injection par inputPump, seule
+	// goroutine à utiliser scroll
+	input          *inputQueue
+	scroll         scrollAccumulator
+	inputCoalesced atomic.Uint64
+	inputDropped   atomic.Uint64
+
+	// Touches et boutons enfoncés, relâchés à la déconnexion
+	pressed pressedInputs
+	touch   touchGesture
+
+	// Dernières copies échangées, pour "history" / "apply"
+	clipHistory clipboardHistory
+
+	// Dernier contenu de chaque sélection poussé par les observateurs du
+	// presse-papiers, remplacé par le suivant s'il n'est pas encore parti
+	clipMu        sync.Mutex
+	clipVersion   map[string]uint64
+	clipboardPush chan []byte
+	primaryPush   chan []byte
+
+	// Saisie "typeText" en cours, fermé pour l'annuler
+	typingMu     sync.Mutex
+	typingCancel chan struct{}
+
+	healthMu   sync.Mutex
+	degraded   map[string]bool
+	lastReport map[string]time.Time
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func newClient(conn *websocket.Conn, keyframeInterval time.Duration) *client {
+	c := &client{
+		conn:       conn,
+		delta:      newDeltaEncoder(keyframeInterval),
+		frames:     make(chan outFrame, clientQueueSize),
+		control:    make(chan []byte, clientControlQueue),
+		done:       make(chan struct{}),
+		connected:  time.Now(),
+		fpsChanged: make(chan int, 1),
+		refresh:    make(chan struct{}, 1),
+		limiter:    newRateLimiter(0),
+		input:      newInputQueue(),
+		degraded:   make(map[string]bool),
+		lastReport: make(map[string]time.Time),
+
+		clipVersion:   make(map[string]uint64),
+		clipboardPush: make(chan []byte, 1),
+		primaryPush:   make(chan []byte, 1),
+	}
+	c.screen.Store(-1)
+	c.fps.Store(10)
+	c.protocol.Store(legacyProtocolVersion)
+	return c
+}
+
+func (c *client) setFPS(fps int) {
+	c.fps.Store(int32(fps))
+	select {
+	case c.fpsChanged <- fps:
+	default:
+		// Une valeur est déjà en attente : on la remplace
+		select {
+		case <-c.fpsChanged:
+		default:
+		}
+		c.fpsChanged <- fps
+	}
+}
+
+// requestRefresh est aussi un point de resynchronisation : même si la demande
+// ne peut pas être traitée tout de suite, la prochaine frame sera complète.
+func (c *client) requestRefresh() {
+	c.delta.requestKeyframe()
+	select {
+	case c.refresh <- struct{}{}:
+	default:
+	}
+}
+
+func (c *client) jpegQuality(fps int) int {
+	if q := int(c.quality.Load()); q > 0 {
+		return q
+	}
+
+	quality := 70
+	if fps >= 90 {
+		quality = 30
+	} else if fps >= 60 {
+		quality = 35
+	} else if fps >= 30 {
+		quality = 50
+	} else if fps <= 5 {
+		quality = 90
+	}
+	return quality
+}
+
+// queueFrame ne bloque jamais : si la file est pleine, la frame la plus
+// ancienne est abandonnée. Abandonner une frame du mode tiles désynchronise
+// le canvas du navigateur, on force donc une keyframe au prochain tick.
+func (c *client) queueFrame(f outFrame) {
+	for {
+		select {
+		case c.frames <- f:
+			return
+		default:
+		}
+
+		select {
+		case old := <-c.frames:
+			c.dropped.Add(1)
+			if old.delta {
+				c.delta.requestKeyframe()
+			}
+		default:
+		}
+	}
+}
+
+// sendJSON met en file un message texte. Contrairement aux frames, les
+// messages de contrôle ne sont jamais abandonnés.
+func (c *client) sendJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	select {
+	case c.control <- data:
+		return nil
+	case <-c.done:
+		return fmt.Errorf("client déconnecté")
+	}
+}
+
+func (c *client) sendEvent(eventType string, data interface{}) error {
+	event, err := newControlEvent(eventType, data)
+	if err != nil {
+		return err
+	}
+	return c.sendJSON(event)
+}
+
+// trySendEvent met un message en file sans attendre : il est abandonné si
+// la file de contrôle est pleine.
+func (c *client) trySendEvent(eventType string, data interface{}) bool {
+	event, err := newControlEvent(eventType, data)
+	if err != nil {
+		return false
+	}
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return false
+	}
+	select {
+	case c.control <- raw:
+		return true
+	default:
+		return false
+	}
+}
+
+// pushClipboard met en file, sans jamais bloquer, le message data portant
+// la version d'une sélection. Un contenu pas encore envoyé est remplacé ;
+// une version déjà dépassée est ignorée.
+func (c *client) pushClipboard(selection string, version uint64, data []byte) {
+	ch := c.clipboardPush
+	if selection == selectionPrimary {
+		ch = c.primaryPush
+	}
+
+	c.clipMu.Lock()
+	defer c.clipMu.Unlock()
+	if version <= c.clipVersion[selection] {
+		return
+	}
+	c.clipVersion[selection] = version
+	select {
+	case <-ch:
+	default:
+	}
+	// Seul pushClipboard écrit dans ch, sous clipMu : la place est libre
+	ch <- data
+}
+
+// Les mouvements souris arrivent à 60 Hz : une panne d'injection n'est
+// signalée qu'une fois par intervalle pour ne pas inonder le navigateur.
+const failureReportInterval = 2 * time.Second
+
+// reportResult remonte au navigateur le résultat d'une opération d'un service
+// (input, clipboard) : un "error" à chaque échec (limité en fréquence) et un
+// "status" à chaque changement d'état dégradé / rétabli. Ces messages ne
+// bloquent jamais l'appelant, qui peut servir plusieurs clients : un client
+// dont la file de contrôle est pleine ne les reçoit pas.
+func (c *client) reportResult(service, event string, err error) {
+	c.healthMu.Lock()
+	wasDegraded := c.degraded[service]
+	c.degraded[service] = err != nil
+	report := err != nil && time.Since(c.lastReport[service]) >= failureReportInterval
+	if report {
+		c.lastReport[service] = time.Now()
+	}
+	c.healthMu.Unlock()
+
+	if err == nil {
+		if wasDegraded {
+			c.trySendEvent("status", StatusEvent{Service: service})
+		}
+		return
+	}
+
+	if !wasDegraded {
+		c.trySendEvent("status", StatusEvent{Service: service, Degraded: true, Message: err.Error()})
+	}
+	if report {
+		code := errInputFailed
+		if service == serviceClipboard {
+			code = errClipboardFail
+		}
+		c.trySendEvent("error", ErrorEvent{Code: code, Event: event, Message: err.Error()})
+	}
+}
+
+func (c *client) writePump(onError func(*client)) {
+	for {
+		// Les messages de contrôle passent avant les frames en attente
+		select {
+		case data := <-c.control:
+			if !c.write(websocket.TextMessage, data, onError) {
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case <-c.done:
+			return
+		case data := <-c.control:
+			if !c.write(websocket.TextMessage, data, onError) {
+				return
+			}
+		case data := <-c.clipboardPush:
+			if !c.write(websocket.TextMessage, data, onError) {
+				return
+			}
+		case data := <-c.primaryPush:
+			if !c.write(websocket.TextMessage, data, onError) {
+				return
+			}
+		case f := <-c.frames:
+			if !c.write(websocket.BinaryMessage, f.data, onError) {
+				return
+			}
+			c.sent.Add(1)
+		}
+	}
+}
+
+func (c *client) write(messageType int, data []byte, onError func(*client)) bool {
+	c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+	if err := c.conn.WriteMessage(messageType, data); err != nil {
+		log.Printf("Erreur envoi client %d: %v", c.id, err)
+		onError(c)
+		return false
+	}
+	return true
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+type clientStats struct {
+	ID        uint64 `json:"id"`
+	Remote    string `json:"remote"`
+	Connected string `json:"connected"`
+	Protocol  int    `json:"protocol"`
+	Tiles     bool   `json:"tiles"`
+	Screen    int    `json:"screen"`
+	FPS       int    `json:"fps"`
+	Quality   int    `json:"quality"`
+	Sent      uint64 `json:"sent"`
+	Dropped   uint64 `json:"dropped"`
+	Queued    int    `json:"queued"`
+
+	InputQueued    int    `json:"inputQueued"`
+	InputCoalesced uint64 `json:"inputCoalesced"`
+	InputDropped   uint64 `json:"inputDropped"`
+}
+
+func (c *client) stats() clientStats {
+	return clientStats{
+		ID:        c.id,
+		Remote:    c.conn.RemoteAddr().String(),
+		Connected: c.connected.Format(time.RFC3339),
+		Protocol:  int(c.protocol.Load()),
+		Tiles:     c.delta.enabled.Load(),
+		Screen:    int(c.screen.Load()),
+		FPS:       int(c.fps.Load()),
+		Quality:   int(c.quality.Load()),
+		Sent:      c.sent.Load(),
+		Dropped:   c.dropped.Load(),
+		Queued:    len(c.frames),
+
+		InputQueued:    c.input.len(),
+		InputCoalesced: c.inputCoalesced.Load(),
+		InputDropped:   c.inputDropped.Load(),
+	}
+}
+
+// clientRegistry est l'ensemble des sessions connectées, partagé entre les
+// goroutines de lecture, d'écriture et de streaming.
+type clientRegistry struct {
+	mu      sync.RWMutex
+	clients map[*client]struct{}
+	nextID  uint64
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{clients: make(map[*client]struct{})}
+}
+
+func (r *clientRegistry) add(c *client) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	c.id = r.nextID
+	r.clients[c] = struct{}{}
+	return len(r.clients)
+}
+
+// remove renvoie false si le client avait déjà été retiré.
+func (r *clientRegistry) remove(c *client) (bool, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.clients[c]; !ok {
+		return false, len(r.clients)
+	}
+	delete(r.clients, c)
+	return true, len(r.clients)
+}
+
+func (r *clientRegistry) contains(c *client) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.clients[c]
+	return ok
+}
+
+func (r *clientRegistry) find(id uint64) *client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for c := range r.clients {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (r *clientRegistry) len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.clients)
+}
+
+func (r *clientRegistry) snapshot() []*client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]*client, 0, len(r.clients))
+	for c := range r.clients {
+		list = append(list, c)
+	}
+	return list
+}
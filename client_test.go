@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testConns ouvre n connexions websocket locales et renvoie, pour chacune,
+// le côté serveur et le côté navigateur.
+func testConns(t *testing.T, n int) (servers, browsers []*websocket.Conn) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, n)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	for i := 0; i < n; i++ {
+		browser, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { browser.Close() })
+		browsers = append(browsers, browser)
+		servers = append(servers, <-accepted)
+	}
+	return servers, browsers
+}
+
+// Ajouts, retraits et diffusions concurrents, à lancer avec go test -race.
+func TestClientRegistryConcurrent(t *testing.T) {
+	const n = 8
+	servers, browsers := testConns(t, n)
+	s := NewScreenStreamer(&syntheticCapturer{})
+
+	for _, b := range browsers {
+		go func(b *websocket.Conn) {
+			for {
+				if _, _, err := b.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}(b)
+	}
+
+	var wg sync.WaitGroup
+	clients := make(chan *client, n)
+	for _, conn := range servers {
+		wg.Add(1)
+		go func(conn *websocket.Conn) {
+			defer wg.Done()
+			clients <- s.addClient(conn)
+		}(conn)
+	}
+
+	stop := make(chan struct{})
+	var broadcasters sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		broadcasters.Add(1)
+		go func() {
+			defer broadcasters.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, c := range s.clients.snapshot() {
+					c.sendEvent("status", StatusEvent{Service: serviceInput})
+					c.queueFrame(outFrame{data: []byte{0xFF}})
+				}
+				s.clients.len()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(clients)
+	var removers sync.WaitGroup
+	for c := range clients {
+		removers.Add(2)
+		// Retrait en double, comme writePump et la lecture qui échouent ensemble
+		go func(c *client) { defer removers.Done(); s.removeClient(c) }(c)
+		go func(c *client) { defer removers.Done(); s.removeClient(c) }(c)
+	}
+	removers.Wait()
+	close(stop)
+	broadcasters.Wait()
+
+	if got := s.clients.len(); got != 0 {
+		t.Errorf("%d client(s) encore enregistrés", got)
+	}
+}
+
+func TestQueueFrameDropsOldest(t *testing.T) {
+	c := newClient(nil, 0)
+
+	var wg sync.WaitGroup
+	const producers, frames = 4, 100
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < frames; i++ {
+				c.queueFrame(outFrame{data: []byte{byte(p), byte(i)}, delta: true})
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	if got := len(c.frames); got != clientQueueSize {
+		t.Errorf("%d frame(s) en file, attendu %d", got, clientQueueSize)
+	}
+	if got := c.dropped.Load(); got != producers*frames-clientQueueSize {
+		t.Errorf("%d frame(s) abandonnées, attendu %d", got, producers*frames-clientQueueSize)
+	}
+	if !c.delta.keyframeRequested.Load() {
+		t.Error("une frame tiles abandonnée doit demander une keyframe")
+	}
+
+	// Chaque producteur garde l'ordre de ses propres frames
+	last := map[byte]int{}
+	for len(c.frames) > 0 {
+		f := <-c.frames
+		if prev, ok := last[f.data[0]]; ok && int(f.data[1]) <= prev {
+			t.Errorf("producteur %d: frame %d après %d", f.data[0], f.data[1], prev)
+		}
+		last[f.data[0]] = int(f.data[1])
+	}
+}
+
+// Les messages de contrôle ne sont jamais abandonnés, même quand des frames
+// arrivent en même temps et que writePump les abandonne.
+func TestWritePumpDeliversAllControlMessages(t *testing.T) {
+	servers, browsers := testConns(t, 1)
+	c := newClient(servers[0], 0)
+	go c.writePump(func(*client) { t.Error("erreur d'écriture inattendue") })
+	defer c.close()
+
+	const controls = 200
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < controls; i++ {
+			c.sendEvent("ack", AckEvent{Event: "test"})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			c.queueFrame(outFrame{data: []byte{0xFF, byte(i)}})
+		}
+	}()
+
+	received := 0
+	browsers[0].SetReadDeadline(time.Now().Add(5 * time.Second))
+	for received < controls {
+		messageType, _, err := browsers[0].ReadMessage()
+		if err != nil {
+			t.Fatalf("%d message(s) de contrôle reçus sur %d: %v", received, controls, err)
+		}
+		if messageType == websocket.TextMessage {
+			received++
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xfixes"
+	"github.com/jezek/xgb/xproto"
+)
+
+// Sélections X11 synchronisées : CLIPBOARD (Ctrl+C / Ctrl+V) et PRIMARY
+// (texte surligné, collé au clic du milieu), qui n'existe que sous X11.
+const (
+	selectionClipboard = "clipboard"
+	selectionPrimary   = "primary"
+)
+
+// Formats échangés, par ordre de préférence quand la VM en propose plusieurs
+const (
+	mimePNG  = "image/png"
+	mimeHTML = "text/html"
+	mimeText = "text/plain"
+)
+
+var clipboardMimeTypes = []string{mimePNG, mimeHTML, mimeText}
+
+// maxClipboardSize borne un contenu échangé, une fois décodé.
+const maxClipboardSize = 10 << 20
+
+// clipboardContent est le contenu du presse-papiers dans un format MIME ;
+// text en est l'équivalent texte brut, vide pour une image.
+type clipboardContent struct {
+	mime string
+	data []byte
+	text string
+}
+
+func (c clipboardContent) equal(o clipboardContent) bool {
+	return c.mime == o.mime && bytes.Equal(c.data, o.data)
+}
+
+func (c clipboardContent) event(selection, action string) ClipboardEvent {
+	ev := ClipboardEvent{Text: c.text, Action: action}
+	if c.mime != mimeText {
+		ev.Mime = c.mime
+		ev.Data = base64.StdEncoding.EncodeToString(c.data)
+	}
+	if selection != selectionClipboard {
+		ev.Selection = selection
+	}
+	return ev
+}
+
+// sendClipboardContent envoie un contenu venu de la VM et le garde dans
+// l'historique de la session.
+func sendClipboardContent(c *client, selection string, content clipboardContent) {
+	c.sendEvent("clipboard", content.event(selection, "content"))
+	c.clipHistory.add(selection, "vm", content)
+}
+
+// readClipboard lit une sélection de la VM dans le format le plus riche
+// disponible : sous X11, xclip liste les cibles (TARGETS) proposées par
+// l'application propriétaire. Le texte reste le format par défaut.
+func readClipboard(selection string) (clipboardContent, error) {
+	switch {
+	case runtime.GOOS == "linux":
+		if targets, err := exec.Command("xclip", "-o", "-selection", selection, "-t", "TARGETS").Output(); err == nil {
+			available := strings.Fields(string(targets))
+			for _, mime := range []string{mimePNG, mimeHTML} {
+				if !slices.Contains(available, mime) {
+					continue
+				}
+				data, err := exec.Command("xclip", "-o", "-selection", selection, "-t", mime).Output()
+				if err != nil || len(data) == 0 || len(data) > maxClipboardSize {
+					continue
+				}
+				content := clipboardContent{mime: mime, data: data}
+				if mime == mimeHTML {
+					content.text, _ = getClipboard(selection)
+				}
+				return content, nil
+			}
+		}
+	case runtime.GOOS == "windows" && selection == selectionClipboard:
+		if data, err := getClipboardImageWindows(); err == nil && len(data) > 0 && len(data) <= maxClipboardSize {
+			return clipboardContent{mime: mimePNG, data: data}, nil
+		}
+	}
+
+	text, err := getClipboard(selection)
+	return clipboardContent{mime: mimeText, data: []byte(text), text: text}, err
+}
+
+// writeClipboard remplace une sélection de la VM. Un format que le système
+// ne sait pas recevoir est remplacé par son équivalent texte.
+func writeClipboard(selection string, content clipboardContent) error {
+	if content.mime == mimeText {
+		return setClipboard(selection, content.text)
+	}
+
+	switch {
+	case runtime.GOOS == "linux" && (content.mime != mimeHTML || content.text == ""):
+		// xclip ne propose qu'une cible : du HTML seul ne se collerait pas
+		// dans les applications qui attendent du texte, d'où le repli
+		cmd := exec.Command("xclip", "-i", "-selection", selection, "-t", content.mime)
+		cmd.Stdin = bytes.NewReader(content.data)
+		return cmd.Run()
+	case runtime.GOOS == "windows" && selection == selectionClipboard && content.mime == mimePNG:
+		return setClipboardImageWindows(content.data)
+	}
+	if content.text != "" {
+		return setClipboard(selection, content.text)
+	}
+	return fmt.Errorf("format %s non supporté sur %s", content.mime, runtime.GOOS)
+}
+
+func getClipboardImageWindows() ([]byte, error) {
+	psScript := `
+	Add-Type -AssemblyName System.Windows.Forms
+	Add-Type -AssemblyName System.Drawing
+	if ([System.Windows.Forms.Clipboard]::ContainsImage()) {
+		$ms = New-Object System.IO.MemoryStream
+		[System.Windows.Forms.Clipboard]::GetImage().Save($ms, [System.Drawing.Imaging.ImageFormat]::Png)
+		[Convert]::ToBase64String($ms.ToArray())
+	}`
+	output, err := exec.Command("powershell", "-STA", "-Command", psScript).Output()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(output)))
+}
+
+func setClipboardImageWindows(data []byte) error {
+	// L'image passe par l'entrée standard : trop grosse pour la ligne de commande
+	psScript := `
+	Add-Type -AssemblyName System.Windows.Forms
+	Add-Type -AssemblyName System.Drawing
+	$bytes = [Convert]::FromBase64String([Console]::In.ReadToEnd())
+	$ms = New-Object System.IO.MemoryStream(,$bytes)
+	[System.Windows.Forms.Clipboard]::SetImage([System.Drawing.Image]::FromStream($ms))`
+	cmd := exec.Command("powershell", "-STA", "-Command", psScript)
+	cmd.Stdin = strings.NewReader(base64.StdEncoding.EncodeToString(data))
+	return cmd.Run()
+}
+
+// Sans notification du système (Windows, macOS, X sans XFixes), le
+// presse-papiers est relu à cet intervalle.
+const clipboardPollInterval = 2 * time.Second
+
+// clipboardSource signale que le presse-papiers de la VM a peut-être changé.
+// Le canal est fermé si la source s'interrompt d'elle-même.
+type clipboardSource interface {
+	changes() <-chan struct{}
+	close()
+}
+
+func newClipboardSource(selection string) clipboardSource {
+	if runtime.GOOS == "linux" {
+		src, err := newXFixesClipboardSource(selection)
+		if err == nil {
+			return src
+		}
+		log.Printf("Sélection %s: %v, repli sur une lecture toutes les %v", selection, err, clipboardPollInterval)
+	}
+	return newPollClipboardSource()
+}
+
+type pollClipboardSource struct {
+	ticker *time.Ticker
+	ch     chan struct{}
+	done   chan struct{}
+}
+
+func newPollClipboardSource() *pollClipboardSource {
+	p := &pollClipboardSource{
+		ticker: time.NewTicker(clipboardPollInterval),
+		ch:     make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-p.ticker.C:
+			}
+			select {
+			case p.ch <- struct{}{}:
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *pollClipboardSource) changes() <-chan struct{} { return p.ch }
+
+func (p *pollClipboardSource) close() {
+	p.ticker.Stop()
+	close(p.done)
+}
+
+// xfixesClipboardSource écoute les changements de propriétaire d'une
+// sélection : rien n'est lu tant que personne ne copie.
+type xfixesClipboardSource struct {
+	conn *xgb.Conn
+	ch   chan struct{}
+}
+
+func newXFixesClipboardSource(selection string) (*xfixesClipboardSource, error) {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, fmt.Errorf("connexion X impossible: %v", err)
+	}
+	if err := xfixes.Init(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("extension XFixes absente: %v", err)
+	}
+	if _, err := xfixes.QueryVersion(conn, 5, 0).Reply(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("version XFixes: %v", err)
+	}
+
+	name := strings.ToUpper(selection)
+	atom, err := xproto.InternAtom(conn, false, uint16(len(name)), name).Reply()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("atome %s: %v", name, err)
+	}
+	root := xproto.Setup(conn).DefaultScreen(conn).Root
+	mask := uint32(xfixes.SelectionEventMaskSetSelectionOwner |
+		xfixes.SelectionEventMaskSelectionWindowDestroy |
+		xfixes.SelectionEventMaskSelectionClientClose)
+	if err := xfixes.SelectSelectionInputChecked(conn, root, atom.Atom, mask).Check(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("abonnement XFixes: %v", err)
+	}
+
+	x := &xfixesClipboardSource{conn: conn, ch: make(chan struct{}, 1)}
+	go x.loop()
+	return x, nil
+}
+
+func (x *xfixesClipboardSource) loop() {
+	defer close(x.ch)
+	for {
+		ev, err := x.conn.WaitForEvent()
+		if ev == nil && err == nil {
+			// Connexion fermée
+			return
+		}
+		if _, ok := ev.(xfixes.SelectionNotifyEvent); !ok {
+			continue
+		}
+		select {
+		case x.ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (x *xfixesClipboardSource) changes() <-chan struct{} { return x.ch }
+
+func (x *xfixesClipboardSource) close() {
+	x.conn.Close()
+}
+
+// clipboardWatcher surveille le presse-papiers de la VM pour tous les
+// clients à la fois : une seule source, démarrée avec le premier abonné et
+// arrêtée avec le dernier.
+type clipboardWatcher struct {
+	selection     string
+	newSource     func(selection string) clipboardSource
+	readClipboard func(selection string) (clipboardContent, error)
+	policy        *clipboardPolicy
+
+	mu      sync.Mutex
+	subs    map[*client]struct{}
+	stop    chan struct{}
+	last    clipboardContent
+	hasLast bool
+
+	// Dernier contenu diffusé, après application de la politique, et son
+	// numéro : un client ne reçoit jamais un contenu plus ancien qu'un autre
+	outgoing    clipboardContent
+	hasOutgoing bool
+	version     uint64
+}
+
+func newClipboardWatcher(selection string, policy *clipboardPolicy) *clipboardWatcher {
+	return &clipboardWatcher{
+		selection:     selection,
+		newSource:     newClipboardSource,
+		readClipboard: readClipboard,
+		policy:        policy,
+		subs:          make(map[*client]struct{}),
+	}
+}
+
+// subscribe ajoute c aux destinataires. Le contenu courant lui est envoyé
+// tout de suite s'il est déjà connu.
+func (w *clipboardWatcher) subscribe(c *client) {
+	w.mu.Lock()
+	select {
+	case <-c.done:
+		// Déjà déconnecté : unsubscribe ne serait plus appelé
+		w.mu.Unlock()
+		return
+	default:
+	}
+	if !w.policy.allows(clipboardVMToBrowser) {
+		w.mu.Unlock()
+		return
+	}
+	w.subs[c] = struct{}{}
+	if w.stop == nil {
+		w.stop = make(chan struct{})
+		w.hasLast, w.hasOutgoing = false, false
+		go w.run(w.stop)
+		w.mu.Unlock()
+		return
+	}
+	current, version, known := w.outgoing, w.version, w.hasOutgoing
+	w.mu.Unlock()
+
+	if known {
+		w.push([]*client{c}, version, current)
+	}
+}
+
+// push envoie un contenu aux abonnés, hors de w.mu : il est encodé une fois
+// et mis en file sans attendre les clients lents.
+func (w *clipboardWatcher) push(subs []*client, version uint64, content clipboardContent) {
+	event, err := newControlEvent("clipboard", content.event(w.selection, "content"))
+	if err != nil {
+		log.Printf("Sélection %s: encodage impossible: %v", w.selection, err)
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Sélection %s: encodage impossible: %v", w.selection, err)
+		return
+	}
+	for _, c := range subs {
+		c.pushClipboard(w.selection, version, data)
+		c.clipHistory.add(w.selection, "vm", content)
+	}
+}
+
+func (w *clipboardWatcher) unsubscribe(c *client) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.subs[c]; !ok {
+		return
+	}
+	delete(w.subs, c)
+	if len(w.subs) == 0 && w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+}
+
+func (w *clipboardWatcher) run(stop chan struct{}) {
+	src := w.newSource(w.selection)
+	defer func() { src.close() }()
+
+	w.read(stop)
+	for {
+		select {
+		case <-stop:
+			return
+		case _, ok := <-src.changes():
+			if !ok {
+				log.Printf("Sélection %s: notifications interrompues, repli sur une lecture toutes les %v", w.selection, clipboardPollInterval)
+				src.close()
+				src = newPollClipboardSource()
+				continue
+			}
+		}
+		w.read(stop)
+	}
+}
+
+// read relit le presse-papiers et diffuse son contenu s'il a changé.
+func (w *clipboardWatcher) read(stop chan struct{}) {
+	content, err := w.readClipboard(w.selection)
+
+	w.mu.Lock()
+	select {
+	case <-stop:
+		// Arrêté pendant la lecture : un successeur a peut-être déjà pris la main
+		w.mu.Unlock()
+		return
+	default:
+	}
+	changed := err == nil && (!w.hasLast || !content.equal(w.last))
+	if changed {
+		w.last, w.hasLast = content, true
+		var ferr error
+		w.outgoing, ferr = w.policy.filter(nil, clipboardVMToBrowser, content)
+		w.hasOutgoing = ferr == nil
+		changed = w.hasOutgoing
+		content = w.outgoing
+		if changed {
+			w.version++
+		}
+	}
+	version := w.version
+	subs := make([]*client, 0, len(w.subs))
+	for c := range w.subs {
+		subs = append(subs, c)
+	}
+	w.mu.Unlock()
+
+	if changed {
+		w.push(subs, version, content)
+	}
+	if clipboardReportable(err) {
+		for _, c := range subs {
+			c.reportResult(serviceClipboard, "clipboard", err)
+		}
+	}
+}
+
+// clipboardReportable indique si le résultat d'une lecture compte pour
+// l'état du service : xclip échoue aussi quand le presse-papiers est vide,
+// seule l'absence de l'outil est signalée.
+func clipboardReportable(err error) bool {
+	var execErr *exec.Error
+	return err == nil || errors.As(err, &execErr)
+}
+
+// selectionWatcher renvoie l'observateur d'une sélection ("" = CLIPBOARD).
+func (s *ScreenStreamer) selectionWatcher(selection string) *clipboardWatcher {
+	if selection == selectionPrimary {
+		return s.primary
+	}
+	return s.clipboard
+}
+
+func (s *ScreenStreamer) handleClipboardEvent(c *client, ev *ClipboardEvent) error {
+	selection := ev.selection()
+	policy := s.clipboard.policy
+	if selection == selectionPrimary && runtime.GOOS != "linux" {
+		return &protocolError{Code: errClipboardFail, Message: "sélection PRIMARY disponible seulement sous X11"}
+	}
+
+	switch ev.Action {
+	case "get":
+		if err := policy.check(c, clipboardVMToBrowser, clipboardContent{}); err != nil {
+			return err
+		}
+		content, err := readClipboard(selection)
+		if err != nil {
+			log.Printf("Erreur lecture clipboard: %v", err)
+		} else {
+			if content, err = policy.filter(c, clipboardVMToBrowser, content); err != nil {
+				return err
+			}
+			sendClipboardContent(c, selection, content)
+		}
+		if clipboardReportable(err) {
+			c.reportResult(serviceClipboard, "clipboard", err)
+		}
+
+	case "set":
+		content, err := policy.filter(c, clipboardBrowserToVM, ev.content())
+		if err != nil {
+			return err
+		}
+		err = writeClipboard(selection, content)
+		if err != nil {
+			log.Printf("Erreur écriture clipboard: %v", err)
+		} else {
+			c.clipHistory.add(selection, "browser", content)
+			c.sendEvent("ack", AckEvent{Event: "clipboard", Action: "set"})
+		}
+		c.reportResult(serviceClipboard, "clipboard", err)
+
+	case "watch":
+		if err := policy.check(c, clipboardVMToBrowser, clipboardContent{}); err != nil {
+			return err
+		}
+		s.selectionWatcher(selection).subscribe(c)
+
+	case "unwatch":
+		s.selectionWatcher(selection).unsubscribe(c)
+
+	case "history":
+		c.sendEvent("clipboardHistory", ClipboardHistoryEvent{Entries: c.clipHistory.list()})
+
+	case "apply":
+		item, ok := c.clipHistory.get(ev.ID)
+		if !ok {
+			return invalidEvent("entrée %d absente de l'historique", ev.ID)
+		}
+		content, err := policy.filter(c, clipboardBrowserToVM, item.content)
+		if err != nil {
+			return err
+		}
+		err = writeClipboard(selection, content)
+		if err != nil {
+			log.Printf("Erreur écriture clipboard: %v", err)
+		} else {
+			c.sendEvent("ack", AckEvent{Event: "clipboard", Action: "apply"})
+		}
+		c.reportResult(serviceClipboard, "clipboard", err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClipboardSource signale les changements à la demande du test.
+type fakeClipboardSource struct {
+	ch     chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (f *fakeClipboardSource) changes() <-chan struct{} { return f.ch }
+func (f *fakeClipboardSource) close()                   { f.once.Do(func() { close(f.closed) }) }
+
+// fakeClipboard tient le contenu que lit l'observateur.
+type fakeClipboard struct {
+	mu   sync.Mutex
+	text string
+}
+
+func (f *fakeClipboard) set(text string) {
+	f.mu.Lock()
+	f.text = text
+	f.mu.Unlock()
+}
+
+func (f *fakeClipboard) read(string) (clipboardContent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return clipboardContent{mime: mimeText, data: []byte(f.text), text: f.text}, nil
+}
+
+func testWatcher(t *testing.T) (*clipboardWatcher, *fakeClipboard, chan *fakeClipboardSource) {
+	t.Helper()
+	w := newClipboardWatcher(selectionClipboard, &clipboardPolicy{direction: clipboardBidirectional, maxSize: maxClipboardSize})
+	clip := &fakeClipboard{}
+	sources := make(chan *fakeClipboardSource, 4)
+	w.readClipboard = clip.read
+	w.newSource = func(string) clipboardSource {
+		src := &fakeClipboardSource{ch: make(chan struct{}), closed: make(chan struct{})}
+		sources <- src
+		return src
+	}
+	return w, clip, sources
+}
+
+// pushed attend le prochain contenu poussé à c et renvoie son texte.
+func pushed(t *testing.T, c *client) string {
+	t.Helper()
+	select {
+	case data := <-c.clipboardPush:
+		var event ControlEvent
+		var ev ClipboardEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(event.Data, &ev); err != nil {
+			t.Fatal(err)
+		}
+		return ev.Text
+	case <-time.After(2 * time.Second):
+		t.Fatal("aucun contenu poussé")
+		return ""
+	}
+}
+
+func TestClipboardWatcherLifecycle(t *testing.T) {
+	w, clip, sources := testWatcher(t)
+	clip.set("premier")
+
+	// Le premier abonné démarre la source et reçoit le contenu initial
+	a := newClient(nil, 0)
+	w.subscribe(a)
+	src := <-sources
+	if got := pushed(t, a); got != "premier" {
+		t.Errorf("contenu initial %q", got)
+	}
+
+	// Un abonné suivant reçoit le contenu connu sans nouvelle source
+	b := newClient(nil, 0)
+	w.subscribe(b)
+	if got := pushed(t, b); got != "premier" {
+		t.Errorf("contenu à l'abonnement %q", got)
+	}
+	if len(sources) != 0 {
+		t.Error("seconde source démarrée")
+	}
+
+	clip.set("second")
+	src.ch <- struct{}{}
+	for _, c := range []*client{a, b} {
+		if got := pushed(t, c); got != "second" {
+			t.Errorf("client %p: contenu %q après changement", c, got)
+		}
+	}
+
+	// Le dernier désabonnement arrête la source
+	stop := w.stop
+	w.unsubscribe(a)
+	select {
+	case <-src.closed:
+		t.Fatal("source arrêtée avec un abonné restant")
+	default:
+	}
+	w.unsubscribe(b)
+	select {
+	case <-src.closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("source non arrêtée après le dernier désabonnement")
+	}
+
+	// Une lecture terminée après l'arrêt est ignorée, même pour un nouvel abonné
+	c := newClient(nil, 0)
+	w.mu.Lock()
+	w.subs[c] = struct{}{}
+	w.mu.Unlock()
+	clip.set("tardif")
+	w.read(stop)
+	select {
+	case data := <-c.clipboardPush:
+		t.Errorf("lecture tardive diffusée: %s", data)
+	default:
+	}
+	if w.hasLast && string(w.last.data) == "tardif" {
+		t.Error("lecture tardive mémorisée")
+	}
+}
+
+// Un client qui ne lit plus ne bloque ni l'observateur ni les autres : seul
+// le dernier contenu reste en attente pour lui.
+func TestClipboardWatcherSlowClient(t *testing.T) {
+	w, clip, sources := testWatcher(t)
+	slow, fast := newClient(nil, 0), newClient(nil, 0)
+	slow.clipHistory.limit = 10
+	for len(slow.control) < cap(slow.control) {
+		slow.control <- []byte("{}")
+	}
+
+	clip.set("v1")
+	w.subscribe(slow)
+	src := <-sources
+	w.subscribe(fast)
+	if got := pushed(t, fast); got != "v1" {
+		t.Fatalf("contenu %q", got)
+	}
+	for _, text := range []string{"v2", "v3"} {
+		clip.set(text)
+		done := make(chan struct{})
+		go func() { src.ch <- struct{}{}; close(done) }()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("observateur bloqué par un client lent")
+		}
+		if got := pushed(t, fast); got != text {
+			t.Errorf("client rapide: %q, attendu %q", got, text)
+		}
+	}
+
+	if got := pushed(t, slow); got != "v3" {
+		t.Errorf("client lent: %q, attendu le dernier contenu", got)
+	}
+	if n := len(slow.clipHistory.list()); n != 3 {
+		t.Errorf("client lent: %d entrée(s) d'historique, attendu 3", n)
+	}
+	w.unsubscribe(slow)
+	w.unsubscribe(fast)
+}
+
+func TestPushClipboardIgnoresOlderVersion(t *testing.T) {
+	c := newClient(nil, 0)
+	c.pushClipboard(selectionClipboard, 2, []byte("v2"))
+	c.pushClipboard(selectionClipboard, 1, []byte("v1"))
+	c.pushClipboard(selectionPrimary, 1, []byte("p1"))
+	if got := string(<-c.clipboardPush); got != "v2" {
+		t.Errorf("CLIPBOARD: %s, attendu v2", got)
+	}
+	if got := string(<-c.primaryPush); got != "p1" {
+		t.Errorf("PRIMARY: %s, attendu p1", got)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Volume total gardé par session, en plus du nombre d'entrées
+	// (-clipboard-history) : les plus anciennes sont oubliées au-delà.
+	clipboardHistoryBytes = 32 << 20
+	clipboardPreviewRunes = 80
+
+	// Volume total gardé par l'ensemble des sessions, par défaut
+	// (-clipboard-history-max)
+	clipboardHistoryBudget = 256 << 20
+)
+
+// clipboardBudget borne la mémoire de tous les historiques : une session
+// qui ne trouve plus de place oublie d'abord ses propres copies, puis
+// renonce à garder la nouvelle.
+type clipboardBudget struct {
+	mu   sync.Mutex
+	max  int
+	used int
+}
+
+func (b *clipboardBudget) reserve(n int) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.max > 0 && b.used+n > b.max {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+func (b *clipboardBudget) release(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+}
+
+// clipboardHistory garde les dernières copies échangées par une session,
+// dans les deux sens, pour que le navigateur puisse en recoller une.
+type clipboardHistory struct {
+	mu     sync.Mutex
+	limit  int
+	nextID uint64
+	items  []clipboardHistoryItem // du plus ancien au plus récent
+	size   int
+	budget *clipboardBudget
+}
+
+type clipboardHistoryItem struct {
+	id        uint64
+	selection string
+	source    string
+	at        time.Time
+	content   clipboardContent
+}
+
+func (h *clipboardHistory) add(selection, source string, content clipboardContent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.limit <= 0 || len(content.data) == 0 {
+		return
+	}
+	// Une copie renvoyée telle quelle (écho d'un "set", PRIMARY puis
+	// CLIPBOARD) ne crée pas de doublon
+	if n := len(h.items); n > 0 && h.items[n-1].content.equal(content) {
+		return
+	}
+
+	for !h.budget.reserve(len(content.data)) {
+		if len(h.items) == 0 {
+			return
+		}
+		h.dropOldest()
+	}
+	h.nextID++
+	h.items = append(h.items, clipboardHistoryItem{id: h.nextID, selection: selection, source: source, at: time.Now(), content: content})
+	h.size += len(content.data)
+	for len(h.items) > h.limit || (h.size > clipboardHistoryBytes && len(h.items) > 1) {
+		h.dropOldest()
+	}
+}
+
+func (h *clipboardHistory) dropOldest() {
+	h.size -= len(h.items[0].content.data)
+	h.budget.release(len(h.items[0].content.data))
+	h.items[0] = clipboardHistoryItem{}
+	h.items = h.items[1:]
+}
+
+// clear oublie tout l'historique à la fin de la session ; les copies
+// encore en cours de traitement ne sont plus gardées.
+func (h *clipboardHistory) clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.budget.release(h.size)
+	h.items, h.size, h.limit = nil, 0, 0
+}
+
+func (h *clipboardHistory) get(id uint64) (clipboardHistoryItem, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, item := range h.items {
+		if item.id == id {
+			return item, true
+		}
+	}
+	return clipboardHistoryItem{}, false
+}
+
+func (h *clipboardHistory) list() []ClipboardHistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := make([]ClipboardHistoryEntry, 0, len(h.items))
+	for i := len(h.items) - 1; i >= 0; i-- {
+		item := h.items[i]
+		entries = append(entries, ClipboardHistoryEntry{
+			ID:        item.id,
+			Selection: item.selection,
+			Source:    item.source,
+			Mime:      item.content.mime,
+			Preview:   clipboardPreview(item.content.text),
+			Size:      len(item.content.data),
+			Time:      item.at.Format(time.RFC3339),
+		})
+	}
+	return entries
+}
+
+func clipboardPreview(text string) string {
+	if utf8.RuneCountInString(text) <= clipboardPreviewRunes {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:clipboardPreviewRunes]) + "…"
+}
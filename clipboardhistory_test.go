@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testClipboard(b byte, size int) clipboardContent {
+	return clipboardContent{mime: mimeText, data: bytes.Repeat([]byte{b}, size)}
+}
+
+func TestClipboardHistoryBudget(t *testing.T) {
+	budget := &clipboardBudget{max: 100}
+	a := &clipboardHistory{limit: 10, budget: budget}
+	b := &clipboardHistory{limit: 10, budget: budget}
+
+	a.add(selectionClipboard, "vm", testClipboard('1', 40))
+	a.add(selectionClipboard, "vm", testClipboard('2', 40))
+	// Plus de place : a oublie sa plus ancienne copie
+	a.add(selectionClipboard, "vm", testClipboard('3', 40))
+	if got := len(a.list()); got != 2 || budget.used != 80 {
+		t.Fatalf("%d entrée(s), %d octets utilisés ; attendu 2 et 80", got, budget.used)
+	}
+
+	// b n'a rien à oublier : la copie n'est pas gardée
+	b.add(selectionClipboard, "browser", testClipboard('4', 40))
+	if got := len(b.list()); got != 0 || budget.used != 80 {
+		t.Fatalf("b: %d entrée(s), %d octets utilisés ; attendu 0 et 80", got, budget.used)
+	}
+	b.add(selectionClipboard, "browser", testClipboard('5', 20))
+	if got := len(b.list()); got != 1 || budget.used != 100 {
+		t.Fatalf("b: %d entrée(s), %d octets utilisés ; attendu 1 et 100", got, budget.used)
+	}
+
+	// La fin de session rend la place, et plus rien n'est gardé ensuite
+	a.clear()
+	a.add(selectionClipboard, "vm", testClipboard('6', 10))
+	if got := len(a.list()); got != 0 || budget.used != 20 {
+		t.Fatalf("après clear: %d entrée(s), %d octets utilisés ; attendu 0 et 20", got, budget.used)
+	}
+	b.add(selectionClipboard, "browser", testClipboard('7', 80))
+	if got := len(b.list()); got != 2 || budget.used != 100 {
+		t.Fatalf("b: %d entrée(s), %d octets utilisés ; attendu 2 et 100", got, budget.used)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sens des échanges autorisés par -clipboard
+const (
+	clipboardOff           = "off"
+	clipboardVMToBrowser   = "vm-to-browser"
+	clipboardBrowserToVM   = "browser-to-vm"
+	clipboardBidirectional = "both"
+)
+
+// Texte substitué aux passages masqués par -clipboard-redact
+const clipboardRedacted = "[masqué]"
+
+// clipboardPolicy filtre les échanges de presse-papiers : sens autorisés,
+// taille maximale et masquage de secrets par expressions régulières. Chaque
+// refus ou masquage est audité.
+type clipboardPolicy struct {
+	direction string
+	maxSize   int
+	redact    []*regexp.Regexp
+
+	auditMu sync.Mutex
+	audit   io.Writer // nil = log standard
+}
+
+func newClipboardPolicy(direction string, maxSize int) (*clipboardPolicy, error) {
+	if err := oneOf("clipboard", direction, clipboardOff, clipboardVMToBrowser, clipboardBrowserToVM, clipboardBidirectional); err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 || maxSize > maxClipboardSize {
+		return nil, fmt.Errorf("taille maximale %d invalide (1 à %d octets)", maxSize, maxClipboardSize)
+	}
+	return &clipboardPolicy{direction: direction, maxSize: maxSize}, nil
+}
+
+func (p *clipboardPolicy) allows(direction string) bool {
+	return p.direction == clipboardBidirectional || p.direction == direction
+}
+
+// redactFlag accumule les -clipboard-redact successifs.
+type redactFlag []*regexp.Regexp
+
+func (f *redactFlag) String() string {
+	var patterns []string
+	for _, re := range *f {
+		patterns = append(patterns, re.String())
+	}
+	return strings.Join(patterns, ", ")
+}
+
+func (f *redactFlag) Set(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, re)
+	return nil
+}
+
+// check refuse, en l'auditant, un échange dans un sens non autorisé.
+func (p *clipboardPolicy) check(c *client, direction string, content clipboardContent) error {
+	if p.allows(direction) {
+		return nil
+	}
+	p.record(c, direction, "denied", content, "")
+	return &protocolError{Code: errClipboardDenied, Message: fmt.Sprintf("presse-papiers %s désactivé par le serveur", direction)}
+}
+
+// filter applique la politique à un contenu qui part dans direction. c est
+// le client concerné, nil pour une diffusion à tous les abonnés.
+func (p *clipboardPolicy) filter(c *client, direction string, content clipboardContent) (clipboardContent, error) {
+	if err := p.check(c, direction, content); err != nil {
+		return clipboardContent{}, err
+	}
+	if len(content.data) > p.maxSize {
+		p.record(c, direction, "too_large", content, fmt.Sprintf("max %d octets", p.maxSize))
+		return clipboardContent{}, &protocolError{Code: errClipboardDenied, Message: fmt.Sprintf("presse-papiers trop volumineux (%d octets, max %d)", len(content.data), p.maxSize)}
+	}
+	if len(p.redact) == 0 {
+		return content, nil
+	}
+
+	// Une image ne peut pas être masquée : elle passe telle quelle
+	matches := 0
+	redact := func(s string) string {
+		for _, re := range p.redact {
+			s = re.ReplaceAllStringFunc(s, func(string) string {
+				matches++
+				return clipboardRedacted
+			})
+		}
+		return s
+	}
+	content.text = redact(content.text)
+	switch content.mime {
+	case mimeText:
+		content.data = []byte(content.text)
+	case mimeHTML:
+		content.data = []byte(redact(string(content.data)))
+	}
+	if matches > 0 {
+		p.record(c, direction, "redacted", content, fmt.Sprintf("%d passage(s)", matches))
+	}
+	return content, nil
+}
+
+type clipboardAuditEntry struct {
+	Time      string `json:"time"`
+	Client    uint64 `json:"client,omitempty"`
+	Remote    string `json:"remote,omitempty"`
+	Direction string `json:"direction"`
+	Outcome   string `json:"outcome"` // "denied", "too_large", "redacted"
+	Mime      string `json:"mime,omitempty"`
+	Size      int    `json:"size"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// record écrit une entrée d'audit, une ligne JSON par événement. Le contenu
+// lui-même n'est jamais journalisé.
+func (p *clipboardPolicy) record(c *client, direction, outcome string, content clipboardContent, detail string) {
+	entry := clipboardAuditEntry{
+		Time:      time.Now().Format(time.RFC3339),
+		Direction: direction,
+		Outcome:   outcome,
+		Mime:      content.mime,
+		Size:      len(content.data),
+		Detail:    detail,
+	}
+	if c != nil {
+		entry.Client = c.id
+		entry.Remote = c.conn.RemoteAddr().String()
+	}
+	line, _ := json.Marshal(entry)
+
+	p.auditMu.Lock()
+	defer p.auditMu.Unlock()
+	if p.audit == nil {
+		log.Printf("AUDIT presse-papiers: %s", line)
+		return
+	}
+	if _, err := fmt.Fprintf(p.audit, "%s\n", line); err != nil {
+		log.Printf("Erreur écriture audit presse-papiers: %v (%s)", err, line)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClipboardPolicyFilter(t *testing.T) {
+	secret := regexp.MustCompile(`sk-[a-z0-9]+`)
+	png := []byte("\x89PNG\r\n\x1a\nsk-abc123")
+	text := func(s string) clipboardContent {
+		return clipboardContent{mime: mimeText, data: []byte(s), text: s}
+	}
+	html := func(s, alt string) clipboardContent {
+		return clipboardContent{mime: mimeHTML, data: []byte(s), text: alt}
+	}
+
+	tests := []struct {
+		name      string
+		policy    string
+		maxSize   int
+		direction string
+		content   clipboardContent
+		wantErr   bool
+		wantData  string
+		wantText  string
+		outcome   string // entrée d'audit attendue, "" = aucune
+		detail    string
+	}{
+		{"les deux sens, VM vers navigateur", clipboardBidirectional, 64, clipboardVMToBrowser, text("bonjour"), false, "bonjour", "bonjour", "", ""},
+		{"les deux sens, navigateur vers VM", clipboardBidirectional, 64, clipboardBrowserToVM, text("bonjour"), false, "bonjour", "bonjour", "", ""},
+		{"VM vers navigateur seulement, sens autorisé", clipboardVMToBrowser, 64, clipboardVMToBrowser, text("a"), false, "a", "a", "", ""},
+		{"VM vers navigateur seulement, sens refusé", clipboardVMToBrowser, 64, clipboardBrowserToVM, text("a"), true, "", "", "denied", ""},
+		{"navigateur vers VM seulement, sens refusé", clipboardBrowserToVM, 64, clipboardVMToBrowser, text("a"), true, "", "", "denied", ""},
+		{"désactivé, VM vers navigateur", clipboardOff, 64, clipboardVMToBrowser, text("a"), true, "", "", "denied", ""},
+		{"désactivé, navigateur vers VM", clipboardOff, 64, clipboardBrowserToVM, text("a"), true, "", "", "denied", ""},
+		{"taille maximale atteinte", clipboardBidirectional, 8, clipboardBrowserToVM, text("12345678"), false, "12345678", "12345678", "", ""},
+		{"taille maximale dépassée", clipboardBidirectional, 8, clipboardBrowserToVM, text("123456789"), true, "", "", "too_large", "max 8 octets"},
+		{"texte masqué", clipboardBidirectional, 64, clipboardVMToBrowser, text("clé sk-abc123 et sk-def"), false, "clé [masqué] et [masqué]", "clé [masqué] et [masqué]", "redacted", "2 passage(s)"},
+		{"texte sans secret", clipboardBidirectional, 64, clipboardVMToBrowser, text("rien ici"), false, "rien ici", "rien ici", "", ""},
+		{"HTML masqué, texte équivalent compris", clipboardBidirectional, 64, clipboardBrowserToVM, html("<b>sk-abc123</b>", "sk-abc123"), false, "<b>[masqué]</b>", "[masqué]", "redacted", "2 passage(s)"},
+		{"image inchangée", clipboardBidirectional, 64, clipboardVMToBrowser, clipboardContent{mime: mimePNG, data: png}, false, string(png), "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var audit bytes.Buffer
+			p := &clipboardPolicy{direction: tt.policy, maxSize: tt.maxSize, redact: []*regexp.Regexp{secret}, audit: &audit}
+
+			got, err := p.filter(nil, tt.direction, tt.content)
+			if tt.wantErr {
+				var perr *protocolError
+				if !errors.As(err, &perr) || perr.Code != errClipboardDenied {
+					t.Errorf("erreur %v, attendu %s", err, errClipboardDenied)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else {
+				if string(got.data) != tt.wantData || got.text != tt.wantText || got.mime != tt.content.mime {
+					t.Errorf("contenu %s %q (texte %q), attendu %s %q (texte %q)", got.mime, got.data, got.text, tt.content.mime, tt.wantData, tt.wantText)
+				}
+			}
+
+			lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+			if tt.outcome == "" {
+				if audit.Len() != 0 {
+					t.Errorf("audit inattendu: %s", audit.String())
+				}
+				return
+			}
+			if len(lines) != 1 {
+				t.Fatalf("%d ligne(s) d'audit, attendu 1", len(lines))
+			}
+			var entry clipboardAuditEntry
+			if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+				t.Fatal(err)
+			}
+			size := len(tt.content.data)
+			if tt.outcome == "redacted" {
+				size = len(tt.wantData)
+			}
+			want := clipboardAuditEntry{Time: entry.Time, Direction: tt.direction, Outcome: tt.outcome, Mime: tt.content.mime, Size: size, Detail: tt.detail}
+			if entry != want {
+				t.Errorf("audit %+v, attendu %+v", entry, want)
+			}
+			if _, err := time.Parse(time.RFC3339, entry.Time); err != nil {
+				t.Errorf("horodatage %q: %v", entry.Time, err)
+			}
+			// Le contenu lui-même n'est jamais journalisé
+			if strings.Contains(audit.String(), "sk-") || strings.Contains(audit.String(), "123456789") {
+				t.Errorf("contenu présent dans l'audit: %s", audit.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"sync/atomic"
+	"time"
+)
+
+// Format binaire des frames en mode "tiles" (entiers big-endian) :
+//
+//	keyframe : [1][largeur u16][hauteur u16][jpeg...]
+//	delta    : [2][largeur u16][hauteur u16][nb u16] puis nb fois
+//	           [x u16][y u16][l u16][h u16][taille u32][jpeg...]
+//
+// Le premier octet permet au navigateur de distinguer ces frames d'un JPEG
+// brut (0xFF), envoyé aux clients qui n'ont pas activé le mode tiles.
+const (
+	frameKeyframe byte = 1
+	frameDelta    byte = 2
+
+	deltaTileSize = 64
+)
+
+type deltaEncoder struct {
+	enabled           atomic.Bool
+	keyframeRequested atomic.Bool
+	interval          time.Duration
+
+	// Utilisés uniquement par la goroutine de streaming
+	prev         *image.RGBA
+	lastKeyframe time.Time
+}
+
+func newDeltaEncoder(interval time.Duration) *deltaEncoder {
+	return &deltaEncoder{interval: interval}
+}
+
+func (d *deltaEncoder) requestKeyframe() {
+	d.keyframeRequested.Store(true)
+}
+
+// encode renvoie la frame à envoyer pour img, ou nil si rien n'a changé
+// depuis la frame précédente.
+func (d *deltaEncoder) encode(img *image.RGBA, quality int) ([]byte, error) {
+	keyframe := d.keyframeRequested.Swap(false) ||
+		d.prev == nil ||
+		d.prev.Bounds() != img.Bounds() ||
+		(d.interval > 0 && time.Since(d.lastKeyframe) >= d.interval)
+
+	if keyframe {
+		data, err := encodeKeyframe(img, quality)
+		if err != nil {
+			return nil, err
+		}
+		d.prev = img
+		d.lastKeyframe = time.Now()
+		return data, nil
+	}
+
+	rects := changedRects(d.prev, img)
+	if len(rects) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	bounds := img.Bounds()
+	buf.WriteByte(frameDelta)
+	binary.Write(&buf, binary.BigEndian, [3]uint16{uint16(bounds.Dx()), uint16(bounds.Dy()), uint16(len(rects))})
+
+	var tile bytes.Buffer
+	for _, r := range rects {
+		tile.Reset()
+		if err := jpeg.Encode(&tile, img.SubImage(r), &jpeg.Options{Quality: quality}); err != nil {
+			return nil, fmt.Errorf("erreur encodage tile: %v", err)
+		}
+		local := r.Sub(bounds.Min)
+		binary.Write(&buf, binary.BigEndian, [4]uint16{uint16(local.Min.X), uint16(local.Min.Y), uint16(local.Dx()), uint16(local.Dy())})
+		binary.Write(&buf, binary.BigEndian, uint32(tile.Len()))
+		buf.Write(tile.Bytes())
+	}
+
+	d.prev = img
+	return buf.Bytes(), nil
+}
+
+func encodeKeyframe(img *image.RGBA, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	bounds := img.Bounds()
+	buf.WriteByte(frameKeyframe)
+	binary.Write(&buf, binary.BigEndian, [2]uint16{uint16(bounds.Dx()), uint16(bounds.Dy())})
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("erreur encodage: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// changedRects compare les deux images par tuiles de deltaTileSize pixels et
+// renvoie les zones modifiées, fusionnées horizontalement puis verticalement
+// pour limiter le nombre de JPEG à encoder.
+func changedRects(prev, cur *image.RGBA) []image.Rectangle {
+	bounds := cur.Bounds()
+	var rects []image.Rectangle
+	var previousRow []image.Rectangle
+
+	for ty := bounds.Min.Y; ty < bounds.Max.Y; ty += deltaTileSize {
+		var row []image.Rectangle
+		for tx := bounds.Min.X; tx < bounds.Max.X; tx += deltaTileSize {
+			tile := image.Rect(tx, ty, tx+deltaTileSize, ty+deltaTileSize).Intersect(bounds)
+			if !tileChanged(prev, cur, tile) {
+				continue
+			}
+			if n := len(row); n > 0 && row[n-1].Max.X == tile.Min.X {
+				row[n-1].Max.X = tile.Max.X
+			} else {
+				row = append(row, tile)
+			}
+		}
+
+		// Prolonge vers le bas les zones de la rangée précédente de même largeur
+		var next []image.Rectangle
+		for _, r := range row {
+			merged := false
+			for i, p := range previousRow {
+				if p.Min.X == r.Min.X && p.Max.X == r.Max.X && p.Max.Y == r.Min.Y {
+					previousRow[i].Max.Y = r.Max.Y
+					next = append(next, previousRow[i])
+					previousRow[i] = image.Rectangle{}
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				next = append(next, r)
+			}
+		}
+		for _, p := range previousRow {
+			if !p.Empty() {
+				rects = append(rects, p)
+			}
+		}
+		previousRow = next
+	}
+	return append(rects, previousRow...)
+}
+
+func tileChanged(prev, cur *image.RGBA, tile image.Rectangle) bool {
+	for y := tile.Min.Y; y < tile.Max.Y; y++ {
+		a := prev.Pix[prev.PixOffset(tile.Min.X, y):prev.PixOffset(tile.Max.X, y)]
+		b := cur.Pix[cur.PixOffset(tile.Min.X, y):cur.PixOffset(tile.Max.X, y)]
+		if !bytes.Equal(a, b) {
+			return true
+		}
+	}
+	return false
+}
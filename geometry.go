@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// displayGeometry relie les trois repères de coordonnées utilisés entre la
+// capture et l'injection :
+//   - frame : pixels de l'image reçue par le navigateur, après la réduction
+//     côté serveur (-frame-scale) ;
+//   - capture : pixels du Capturer. L'union des écrans peut commencer en
+//     négatif (écran placé à gauche ou au-dessus de l'écran principal) ;
+//   - input : coordonnées attendues par l'injection, en unités logiques sur
+//     un écran dont le facteur DPI n'est pas 1 (-display-scale).
+//
+// Les écrans sont relus à chaque appel : un écran branché ou déplacé est
+// pris en compte sans redémarrer.
+type displayGeometry struct {
+	capturer Capturer
+
+	// Pixels capturés par unité d'entrée : une valeur pour tous les écrans,
+	// ou une par écran dans l'ordre du Capturer (1 pour les suivants)
+	dpiScales []float64
+
+	// Rapport taille frame / taille capturée, 1 = pas de réduction
+	frameScale float64
+}
+
+func newDisplayGeometry(capturer Capturer) *displayGeometry {
+	return &displayGeometry{capturer: capturer, frameScale: 1}
+}
+
+// parseDisplayScales lit "-display-scale" : "1.5" pour tous les écrans ou
+// "2,1" écran par écran.
+func parseDisplayScales(spec string) ([]float64, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var scales []float64
+	for _, part := range strings.Split(spec, ",") {
+		scale, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || scale < 0.5 || scale > 4 {
+			return nil, fmt.Errorf("facteur d'échelle invalide %q (0.5 à 4)", part)
+		}
+		scales = append(scales, scale)
+	}
+	return scales, nil
+}
+
+func (g *displayGeometry) dpiScale(index int) float64 {
+	switch {
+	case len(g.dpiScales) == 1:
+		return g.dpiScales[0]
+	case index >= 0 && index < len(g.dpiScales):
+		return g.dpiScales[index]
+	}
+	return 1
+}
+
+// view renvoie la zone capturée pour screenIndex (-1 = union des écrans).
+func (g *displayGeometry) view(screenIndex int) (image.Rectangle, error) {
+	numDisplays := g.capturer.NumDisplays()
+	if numDisplays == 0 {
+		return image.Rectangle{}, fmt.Errorf("aucun écran détecté")
+	}
+	if screenIndex == -1 {
+		return desktopBounds(g.capturer), nil
+	}
+	if screenIndex < 0 || screenIndex >= numDisplays {
+		return image.Rectangle{}, fmt.Errorf("écran %d non trouvé (max: %d)", screenIndex, numDisplays-1)
+	}
+	return g.capturer.DisplayBounds(screenIndex), nil
+}
+
+// frameSize est la taille de l'image envoyée pour une zone capturée.
+func (g *displayGeometry) frameSize(view image.Rectangle) image.Point {
+	if g.frameScale >= 1 {
+		return view.Size()
+	}
+	return image.Pt(
+		max(1, int(math.Round(float64(view.Dx())*g.frameScale))),
+		max(1, int(math.Round(float64(view.Dy())*g.frameScale))),
+	)
+}
+
+// toCapture convertit un point de la frame en pixel capturé.
+func (g *displayGeometry) toCapture(view image.Rectangle, x, y int) image.Point {
+	size := g.frameSize(view)
+	x = min(max(x, 0), size.X-1)
+	y = min(max(y, 0), size.Y-1)
+	return image.Pt(view.Min.X+x*view.Dx()/size.X, view.Min.Y+y*view.Dy()/size.Y)
+}
+
+// displayAt renvoie l'écran qui contient p, ou le plus proche si p tombe
+// dans un trou de l'union (écrans de hauteurs différentes).
+func (g *displayGeometry) displayAt(p image.Point) int {
+	best, bestDist := 0, math.MaxInt
+	for i := 0; i < g.capturer.NumDisplays(); i++ {
+		bounds := g.capturer.DisplayBounds(i)
+		if p.In(bounds) {
+			return i
+		}
+		dx := max(bounds.Min.X-p.X, 0, p.X-bounds.Max.X+1)
+		dy := max(bounds.Min.Y-p.Y, 0, p.Y-bounds.Max.Y+1)
+		if dist := dx*dx + dy*dy; dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+// inputRect est la zone d'un écran en coordonnées d'injection. Son origine
+// logique reste celle de la capture : seule l'étendue est divisée par le
+// facteur DPI, pour qu'un écran ne chevauche jamais ses voisins.
+func (g *displayGeometry) inputRect(index int) image.Rectangle {
+	b := g.capturer.DisplayBounds(index)
+	scale := g.dpiScale(index)
+	return image.Rectangle{Min: b.Min, Max: b.Min.Add(image.Pt(
+		int(math.Ceil(float64(b.Dx())/scale)),
+		int(math.Ceil(float64(b.Dy())/scale)),
+	))}
+}
+
+// toInput convertit un point de la frame de screenIndex en coordonnées
+// d'injection : origin + (p - origin) / facteur de l'écran. Hors de toute
+// géométrie connue, le point est renvoyé tel quel.
+func (g *displayGeometry) toInput(screenIndex int, x, y int) (int, int) {
+	view, err := g.view(screenIndex)
+	if err != nil || view.Empty() {
+		return x, y
+	}
+	p := g.toCapture(view, x, y)
+
+	index := screenIndex
+	if index == -1 {
+		index = g.displayAt(p)
+		bounds := g.capturer.DisplayBounds(index)
+		p.X = min(max(p.X, bounds.Min.X), bounds.Max.X-1)
+		p.Y = min(max(p.Y, bounds.Min.Y), bounds.Max.Y-1)
+	}
+	scale := g.dpiScale(index)
+	if scale == 1 {
+		return p.X, p.Y
+	}
+	origin := g.capturer.DisplayBounds(index).Min
+	return origin.X + int(math.Floor(float64(p.X-origin.X)/scale)), origin.Y + int(math.Floor(float64(p.Y-origin.Y)/scale))
+}
+
+// inputBounds est l'union des écrans en coordonnées d'injection, pour les
+// backends qui exposent un périphérique absolu (uinput).
+func (g *displayGeometry) inputBounds() image.Rectangle {
+	var bounds image.Rectangle
+	for i := 0; i < g.capturer.NumDisplays(); i++ {
+		bounds = bounds.Union(g.inputRect(i))
+	}
+	return bounds
+}
+
+// scaleFrame réduit img à la taille size par moyenne des pixels couverts,
+// plus lisible sur du texte qu'un simple échantillonnage.
+func scaleFrame(img *image.RGBA, size image.Point) *image.RGBA {
+	src := img.Bounds()
+	if size == src.Size() {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	for y := 0; y < size.Y; y++ {
+		y0 := src.Min.Y + y*src.Dy()/size.Y
+		y1 := max(y0+1, src.Min.Y+(y+1)*src.Dy()/size.Y)
+		for x := 0; x < size.X; x++ {
+			x0 := src.Min.X + x*src.Dx()/size.X
+			x1 := max(x0+1, src.Min.X+(x+1)*src.Dx()/size.X)
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				off := img.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(img.Pix[off])
+					g += uint32(img.Pix[off+1])
+					b += uint32(img.Pix[off+2])
+					a += uint32(img.Pix[off+3])
+					off += 4
+					n++
+				}
+			}
+			off := dst.PixOffset(x, y)
+			dst.Pix[off] = uint8(r / n)
+			dst.Pix[off+1] = uint8(g / n)
+			dst.Pix[off+2] = uint8(b / n)
+			dst.Pix[off+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
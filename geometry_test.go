@@ -0,0 +1,157 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+// Écran principal 1920x1080 et, à sa gauche, un écran 1280x1024 : l'union
+// commence en x = -1280 et laisse un trou sous l'écran de gauche.
+var leftOfPrimary = []image.Rectangle{
+	image.Rect(0, 0, 1920, 1080),
+	image.Rect(-1280, 0, 0, 1024),
+}
+
+func testGeometry(t *testing.T, displays []image.Rectangle, scales []float64, frameScale float64) *displayGeometry {
+	t.Helper()
+	g := newDisplayGeometry(&syntheticCapturer{displays: displays})
+	g.dpiScales = scales
+	g.frameScale = frameScale
+	return g
+}
+
+func TestDisplayGeometryToInput(t *testing.T) {
+	sideBySide, err := newSyntheticCapturer("1920x1080,3840x2160")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		displays   []image.Rectangle
+		scales     []float64
+		frameScale float64
+		screen     int
+		x, y       int
+		wantX      int
+		wantY      int
+	}{
+		{"écran seul", sideBySide.displays[:1], nil, 1, 0, 100, 200, 100, 200},
+		{"union à origine négative, coin", leftOfPrimary, nil, 1, -1, 0, 0, -1280, 0},
+		{"union à origine négative, écran principal", leftOfPrimary, nil, 1, -1, 1280, 10, 0, 10},
+		{"écran de gauche seul", leftOfPrimary, nil, 1, 1, 0, 0, -1280, 0},
+		{"trou sous l'écran de gauche", leftOfPrimary, nil, 1, -1, 100, 1050, -1180, 1023},
+		{"trou à la jonction", leftOfPrimary, nil, 1, -1, 1279, 1079, 0, 1079},
+		{"frame réduite de moitié", sideBySide.displays[:1], nil, 0.5, 0, 480, 270, 960, 540},
+		{"frame réduite, union négative", leftOfPrimary, nil, 0.5, -1, 640, 0, 0, 0},
+		{"frame réduite, bord clampé", sideBySide.displays[:1], nil, 0.5, 0, 5000, 5000, 1918, 1078},
+		{"facteur unique", sideBySide.displays[:1], []float64{1.5}, 1, 0, 150, 300, 100, 200},
+		{"facteur par écran, écran à 100 %", sideBySide.displays, []float64{1, 2}, 1, -1, 1000, 500, 1000, 500},
+		{"facteur par écran, origine du second", sideBySide.displays, []float64{1, 2}, 1, 1, 0, 0, 1920, 0},
+		{"facteur par écran, coin du second", sideBySide.displays, []float64{1, 2}, 1, 1, 3839, 2159, 3839, 1079},
+		{"facteur par écran, union", sideBySide.displays, []float64{1, 2}, 1, -1, 1920 + 200, 100, 1920 + 100, 50},
+		{"facteur par écran, origine négative", leftOfPrimary, []float64{1, 2}, 1, 1, 640, 512, -960, 256},
+		{"écran inconnu", leftOfPrimary, nil, 1, 5, 42, 43, 42, 43},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := testGeometry(t, tt.displays, tt.scales, tt.frameScale)
+			x, y := g.toInput(tt.screen, tt.x, tt.y)
+			if x != tt.wantX || y != tt.wantY {
+				t.Errorf("toInput(%d, %d, %d) = (%d, %d), attendu (%d, %d)", tt.screen, tt.x, tt.y, x, y, tt.wantX, tt.wantY)
+			}
+		})
+	}
+}
+
+func TestDisplayGeometryInputBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		displays []image.Rectangle
+		scales   []float64
+		want     image.Rectangle
+	}{
+		{"sans facteur", leftOfPrimary, nil, image.Rect(-1280, 0, 1920, 1080)},
+		{"facteur unique", leftOfPrimary, []float64{2}, image.Rect(-1280, 0, 960, 540)},
+		{"facteur par écran", []image.Rectangle{image.Rect(0, 0, 1920, 1080), image.Rect(1920, 0, 5760, 2160)}, []float64{1, 2}, image.Rect(0, 0, 3840, 1080)},
+		{"facteur par écran, origine négative", leftOfPrimary, []float64{1, 2}, image.Rect(-1280, 0, 1920, 1080)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := testGeometry(t, tt.displays, tt.scales, 1)
+			if got := g.inputBounds(); got != tt.want {
+				t.Errorf("inputBounds() = %v, attendu %v", got, tt.want)
+			}
+			// Les écrans ne se chevauchent pas en coordonnées d'injection
+			for i := range tt.displays {
+				for j := i + 1; j < len(tt.displays); j++ {
+					if overlap := g.inputRect(i).Intersect(g.inputRect(j)); !overlap.Empty() {
+						t.Errorf("écrans %d et %d se chevauchent: %v", i, j, overlap)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDisplayGeometryFrameSize(t *testing.T) {
+	tests := []struct {
+		frameScale float64
+		screen     int
+		want       image.Point
+	}{
+		{1, -1, image.Pt(3200, 1080)},
+		{0.5, -1, image.Pt(1600, 540)},
+		{0.5, 1, image.Pt(640, 512)},
+		{0.25, 0, image.Pt(480, 270)},
+	}
+	for _, tt := range tests {
+		g := testGeometry(t, leftOfPrimary, nil, tt.frameScale)
+		view, err := g.view(tt.screen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := g.frameSize(view); got != tt.want {
+			t.Errorf("frameSize(écran %d, %v) = %v, attendu %v", tt.screen, tt.frameScale, got, tt.want)
+		}
+		img, err := g.capturer.CaptureRect(view)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := scaleFrame(img, tt.want).Bounds().Size(); got != tt.want {
+			t.Errorf("scaleFrame: taille %v, attendu %v", got, tt.want)
+		}
+	}
+}
+
+func TestParseDisplayScales(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []float64
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"1.5", []float64{1.5}, false},
+		{"2, 1", []float64{2, 1}, false},
+		{"0.25", nil, true},
+		{"5", nil, true},
+		{"abc", nil, true},
+		{"1,,2", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDisplayScales(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDisplayScales(%q): erreur %v", tt.spec, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseDisplayScales(%q) = %v, attendu %v", tt.spec, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseDisplayScales(%q) = %v, attendu %v", tt.spec, got, tt.want)
+			}
+		}
+	}
+}
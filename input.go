@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// inputBackend injecte les événements souris et clavier sous Linux.
+type inputBackend interface {
+	Name() string
+	Mouse(x, y int, button string, action string) error
+	Keyboard(ev *KeyboardEvent) error
+	// Scroll défile de dx, dy crans (dy > 0 : bas, dx > 0 : droite)
+	Scroll(x, y, dx, dy int) error
+	Close() error
+}
+
+// smoothScroller est implémenté par les backends capables de défilement
+// haute résolution ; dx, dy sont en 1/120 de cran.
+type smoothScroller interface {
+	SmoothScroll(x, y, dx, dy int) error
+}
+
+// textInput est implémenté par les backends capables de saisir du texte
+// Unicode quelle que soit la disposition clavier de la VM.
+type textInput interface {
+	Text(text string) error
+}
+
+// pressedInputs mémorise, pour un client, les touches et boutons enfoncés
+// et pas encore relâchés, dans l'ordre d'appui.
+type pressedInputs struct {
+	mu      sync.Mutex
+	keys    []KeyboardEvent
+	buttons []pressedButton
+}
+
+// pressedButton est un bouton enfoncé en x, y (coordonnées d'injection,
+// éventuellement négatives), ou en mode relatif à position inconnue.
+type pressedButton struct {
+	x, y     int
+	button   string
+	relative bool
+}
+
+func keyID(ev *KeyboardEvent) string {
+	if ev.Code != "" {
+		return ev.Code
+	}
+	return ev.Key
+}
+
+func (p *pressedInputs) trackKey(ev *KeyboardEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := keyID(ev)
+	for i := range p.keys {
+		if keyID(&p.keys[i]) == id {
+			p.keys = append(p.keys[:i], p.keys[i+1:]...)
+			break
+		}
+	}
+	if ev.Action == "down" {
+		p.keys = append(p.keys, KeyboardEvent{Key: ev.Key, Code: ev.Code, Action: "up"})
+	}
+}
+
+// trackButton enregistre un appui ou relâchement ; x, y sont les
+// coordonnées d'injection déjà ajustées.
+func (p *pressedInputs) trackButton(x, y int, button, action string) {
+	p.track(pressedButton{x: x, y: y, button: button}, action)
+}
+
+// trackRelativeButton enregistre un bouton du mode relatif, relâché sans
+// déplacer le pointeur.
+func (p *pressedInputs) trackRelativeButton(button, action string) {
+	p.track(pressedButton{button: button, relative: true}, action)
+}
+
+func (p *pressedInputs) track(b pressedButton, action string) {
+	if action != "down" && action != "up" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := range p.buttons {
+		if p.buttons[i].button == b.button {
+			p.buttons = append(p.buttons[:i], p.buttons[i+1:]...)
+			break
+		}
+	}
+	if action == "down" {
+		p.buttons = append(p.buttons, b)
+	}
+}
+
+// releaseAll vide l'état et renvoie les touches et boutons à relâcher, dans
+// l'ordre inverse des appuis.
+func (p *pressedInputs) releaseAll() (keys []KeyboardEvent, buttons []pressedButton) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := len(p.keys) - 1; i >= 0; i-- {
+		keys = append(keys, p.keys[i])
+	}
+	for i := len(p.buttons) - 1; i >= 0; i-- {
+		buttons = append(buttons, p.buttons[i])
+	}
+	p.keys, p.buttons = nil, nil
+	return keys, buttons
+}
+
+// linuxInput est le backend choisi au démarrage par -input.
+var linuxInput inputBackend
+
+// newInputBackend ouvre le backend demandé. En mode "auto", XTest est
+// préféré (une seule connexion X, pas de processus par événement), puis
+// uinput (Wayland, console) et enfin xdotool. desktop renvoie l'union des
+// écrans : elle fixe la plage des axes du pointeur uinput.
+func newInputBackend(name string, desktop func() image.Rectangle) (inputBackend, error) {
+	switch name {
+	case "", "auto":
+		if backend, err := newXTestInput(); err == nil {
+			return backend, nil
+		}
+		if backend, err := newUinputInput(desktop); err == nil {
+			return backend, nil
+		}
+		return newXdotoolInput()
+	case "xtest":
+		return newXTestInput()
+	case "uinput":
+		return newUinputInput(desktop)
+	case "xdotool":
+		return newXdotoolInput()
+	default:
+		return nil, fmt.Errorf("backend d'entrée inconnu: %s (auto, xtest, uinput, xdotool)", name)
+	}
+}
+
+// xdotoolInput lance un processus xdotool par événement.
+type xdotoolInput struct{}
+
+func newXdotoolInput() (inputBackend, error) {
+	if _, err := exec.LookPath("xdotool"); err != nil {
+		return nil, fmt.Errorf("xdotool non trouvé: %v", err)
+	}
+	return xdotoolInput{}, nil
+}
+
+func (xdotoolInput) Name() string {
+	return "xdotool"
+}
+
+func (xdotoolInput) Close() error {
+	return nil
+}
+
+func (xdotoolInput) Mouse(x, y int, button string, action string) error {
+	if action == "move" || action == "drag" {
+		cmd := exec.Command("xdotool", "mousemove", strconv.Itoa(x), strconv.Itoa(y))
+		return cmd.Run()
+	} else if action == "down" {
+		buttonNum := "1"
+		if button == "right" {
+			buttonNum = "3"
+		} else if button == "middle" {
+			buttonNum = "2"
+		}
+		cmd := exec.Command("xdotool", "mousemove", strconv.Itoa(x), strconv.Itoa(y), "mousedown", buttonNum)
+		return cmd.Run()
+	} else if action == "up" {
+		buttonNum := "1"
+		if button == "right" {
+			buttonNum = "3"
+		} else if button == "middle" {
+			buttonNum = "2"
+		}
+		cmd := exec.Command("xdotool", "mouseup", buttonNum)
+		return cmd.Run()
+	} else if strings.HasPrefix(action, "scroll:") {
+		// scroll:1 = haut, scroll:-1 = bas
+		parts := strings.Split(action, ":")
+		if len(parts) == 2 {
+			if parts[1] == "1" {
+				cmd := exec.Command("xdotool", "click", "4") // scroll up
+				return cmd.Run()
+			} else if parts[1] == "-1" {
+				cmd := exec.Command("xdotool", "click", "5") // scroll down
+				return cmd.Run()
+			}
+		}
+	}
+	return nil
+}
+
+func (xdotoolInput) Keyboard(ev *KeyboardEvent) error {
+	args := []string{}
+
+	if ev.Action == "down" {
+		args = append(args, "keydown")
+	} else if ev.Action == "up" {
+		args = append(args, "keyup")
+	} else {
+		args = append(args, "key")
+	}
+
+	keyCombo := ""
+	if ev.Ctrl {
+		keyCombo += "ctrl+"
+	}
+	if ev.Alt {
+		keyCombo += "alt+"
+	}
+	if ev.Shift {
+		keyCombo += "shift+"
+	}
+	if ev.Meta {
+		keyCombo += "super+"
+	}
+
+	xKey, ok := xdotoolKeyName(ev)
+	if !ok {
+		return fmt.Errorf("touche non supportée: %q (%s)", ev.Key, ev.Code)
+	}
+
+	keyCombo += xKey
+	args = append(args, keyCombo)
+
+	cmd := exec.Command("xdotool", args...)
+	return cmd.Run()
+}
+
+func (xdotoolInput) Text(text string) error {
+	cmd := exec.Command("xdotool", "type", "--", text)
+	return cmd.Run()
+}
+
+func (xdotoolInput) Scroll(x, y, dx, dy int) error {
+	// Boutons 4 / 5 : haut / bas, 6 / 7 : gauche / droite
+	args := []string{"mousemove", strconv.Itoa(x), strconv.Itoa(y)}
+	for _, axis := range []struct{ n, less, more int }{{dy, 4, 5}, {dx, 6, 7}} {
+		button := axis.more
+		count := axis.n
+		if count < 0 {
+			button, count = axis.less, -count
+		}
+		if count > 0 {
+			args = append(args, "click", "--repeat", strconv.Itoa(count), strconv.Itoa(button))
+		}
+	}
+	cmd := exec.Command("xdotool", args...)
+	return cmd.Run()
+}
+
+func (xdotoolInput) RelativeMouse(dx, dy int, button, action string) error {
+	buttonNum := "1"
+	if button == "right" {
+		buttonNum = "3"
+	} else if button == "middle" {
+		buttonNum = "2"
+	}
+
+	var cmd *exec.Cmd
+	switch action {
+	case "down":
+		cmd = exec.Command("xdotool", "mousedown", buttonNum)
+	case "up":
+		cmd = exec.Command("xdotool", "mouseup", buttonNum)
+	default:
+		cmd = exec.Command("xdotool", "mousemove_relative", "--", strconv.Itoa(dx), strconv.Itoa(dy))
+	}
+	return cmd.Run()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// recordedInput est un backend d'entrée qui note chaque injection au lieu
+// de l'effectuer.
+type recordedInput struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordedInput) record(format string, args ...interface{}) {
+	r.mu.Lock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+	r.mu.Unlock()
+}
+
+// take renvoie les injections notées depuis le dernier appel.
+func (r *recordedInput) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func (r *recordedInput) Name() string { return "test" }
+func (r *recordedInput) Close() error { return nil }
+
+func (r *recordedInput) Mouse(x, y int, button, action string) error {
+	r.record("mouse %d,%d %s %s", x, y, button, action)
+	return nil
+}
+
+func (r *recordedInput) Keyboard(ev *KeyboardEvent) error {
+	r.record("key %s/%s %s", ev.Key, ev.Code, ev.Action)
+	return nil
+}
+
+func (r *recordedInput) Scroll(x, y, dx, dy int) error {
+	r.record("scroll %d,%d %d,%d", x, y, dx, dy)
+	return nil
+}
+
+// recordedTextInput saisit aussi du texte Unicode, comme XTest.
+type recordedTextInput struct {
+	recordedInput
+}
+
+func (r *recordedTextInput) Text(text string) error {
+	r.record("text %s", text)
+	return nil
+}
+
+// useInput remplace le backend Linux le temps du test.
+func useInput(t *testing.T, backend inputBackend) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("backend d'entrée remplaçable sous Linux seulement")
+	}
+	previous := linuxInput
+	linuxInput = backend
+	t.Cleanup(func() { linuxInput = previous })
+}
+
+// testInputPump lance l'injection des entrées d'un client, arrêtée à la fin
+// du test.
+func testInputPump(t *testing.T) (*ScreenStreamer, *client) {
+	t.Helper()
+	s := NewScreenStreamer(&syntheticCapturer{})
+	c := newClient(nil, 0)
+	stopped := make(chan struct{})
+	go func() {
+		s.inputPump(c)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		close(c.done)
+		<-stopped
+	})
+	return s, c
+}
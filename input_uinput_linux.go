@@ -0,0 +1,541 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Constantes de linux/uinput.h et linux/input-event-codes.h
+const (
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiSetRelBit  = 0x40045566
+	uiSetAbsBit  = 0x40045567
+	uiSetPropBit = 0x4004556e
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+
+	evSyn = 0x00
+	evKey = 0x01
+	evRel = 0x02
+	evAbs = 0x03
+
+	synReport      = 0
+	relX           = 0x00
+	relY           = 0x01
+	relHWheel      = 0x06
+	relWheel       = 0x08
+	relWheelHiRes  = 0x0b
+	relHWheelHiRes = 0x0c
+	absX           = 0x00
+	absY           = 0x01
+	absMTSlot      = 0x2f
+	absMTPositionX = 0x35
+	absMTPositionY = 0x36
+	absMTTrackID   = 0x39
+
+	btnTouch        = 0x14a
+	inputPropDirect = 0x01
+
+	btnLeft   = 0x110
+	btnRight  = 0x111
+	btnMiddle = 0x112
+
+	keyMax = 248
+
+	uinputMaxNameSize = 80
+	absCount          = 64
+)
+
+type uinputUserDev struct {
+	Name       [uinputMaxNameSize]byte
+	Bustype    uint16
+	Vendor     uint16
+	Product    uint16
+	Version    uint16
+	EffectsMax uint32
+	Absmax     [absCount]int32
+	Absmin     [absCount]int32
+	Absfuzz    [absCount]int32
+	Absflat    [absCount]int32
+}
+
+type inputEvent struct {
+	Time  syscall.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// uinputInput crée via /dev/uinput un pointeur absolu (type tablette) et un
+// clavier virtuels. Il fonctionne sans serveur X : Wayland, console, etc.
+type uinputInput struct {
+	mu       sync.Mutex
+	pointer  *os.File
+	relative *os.File
+	keyboard *os.File
+	touch    *os.File
+
+	// Union des écrans, relue avant chaque événement absolu : la plage des
+	// axes est fixée à la création, pointeur et écran tactile sont recréés
+	// quand un écran est branché ou déplacé
+	bounds  func() image.Rectangle
+	desktop image.Rectangle
+
+	// Contacts multi-touch : un slot par doigt, tous clients confondus
+	slots     [maxTouchContacts]touchSlot
+	nextTrack int32
+
+	// Reliquat haute résolution pas encore émis en cran REL_WHEEL
+	wheelX, wheelY int
+}
+
+func newUinputInput(bounds func() image.Rectangle) (inputBackend, error) {
+	desktop := bounds()
+	if desktop.Empty() {
+		return nil, fmt.Errorf("géométrie d'écran inconnue")
+	}
+
+	pointer, err := createUinputPointer(desktop)
+	if err != nil {
+		return nil, err
+	}
+
+	// Souris relative séparée, qui porte aussi la molette : libinput ne
+	// mélange pas axes absolus et relatifs sur un même périphérique
+	relative, err := createUinputDevice("vm-desktop-streamer relative mouse", func(f *os.File, dev *uinputUserDev) error {
+		for _, bit := range []uintptr{evKey, evRel, evSyn} {
+			if err := uinputIoctl(f, uiSetEvBit, bit); err != nil {
+				return err
+			}
+		}
+		for _, btn := range []uintptr{btnLeft, btnRight, btnMiddle} {
+			if err := uinputIoctl(f, uiSetKeyBit, btn); err != nil {
+				return err
+			}
+		}
+		for _, rel := range []uintptr{relX, relY, relWheel, relHWheel, relWheelHiRes, relHWheelHiRes} {
+			if err := uinputIoctl(f, uiSetRelBit, rel); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		destroyUinputDevice(pointer)
+		return nil, err
+	}
+
+	keyboard, err := createUinputDevice("vm-desktop-streamer keyboard", func(f *os.File, dev *uinputUserDev) error {
+		for _, bit := range []uintptr{evKey, evSyn} {
+			if err := uinputIoctl(f, uiSetEvBit, bit); err != nil {
+				return err
+			}
+		}
+		for code := uintptr(1); code < keyMax; code++ {
+			if err := uinputIoctl(f, uiSetKeyBit, code); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		destroyUinputDevice(pointer)
+		destroyUinputDevice(relative)
+		return nil, err
+	}
+
+	touch, err := createUinputTouch(desktop)
+	if err != nil {
+		destroyUinputDevice(pointer)
+		destroyUinputDevice(relative)
+		destroyUinputDevice(keyboard)
+		return nil, err
+	}
+
+	return &uinputInput{pointer: pointer, relative: relative, keyboard: keyboard, touch: touch, bounds: bounds, desktop: desktop}, nil
+}
+
+// createUinputPointer crée le pointeur absolu, dont les axes couvrent desktop.
+func createUinputPointer(desktop image.Rectangle) (*os.File, error) {
+	return createUinputDevice("vm-desktop-streamer pointer", func(f *os.File, dev *uinputUserDev) error {
+		for _, bit := range []uintptr{evKey, evAbs, evSyn} {
+			if err := uinputIoctl(f, uiSetEvBit, bit); err != nil {
+				return err
+			}
+		}
+		for _, btn := range []uintptr{btnLeft, btnRight, btnMiddle} {
+			if err := uinputIoctl(f, uiSetKeyBit, btn); err != nil {
+				return err
+			}
+		}
+		for _, axis := range []uintptr{absX, absY} {
+			if err := uinputIoctl(f, uiSetAbsBit, axis); err != nil {
+				return err
+			}
+		}
+		dev.Absmax[absX] = int32(desktop.Dx() - 1)
+		dev.Absmax[absY] = int32(desktop.Dy() - 1)
+		return nil
+	})
+}
+
+// createUinputTouch crée l'écran tactile multi-touch (protocole B : slots et
+// tracking id) couvrant desktop.
+func createUinputTouch(desktop image.Rectangle) (*os.File, error) {
+	return createUinputDevice("vm-desktop-streamer touchscreen", func(f *os.File, dev *uinputUserDev) error {
+		for _, bit := range []uintptr{evKey, evAbs, evSyn} {
+			if err := uinputIoctl(f, uiSetEvBit, bit); err != nil {
+				return err
+			}
+		}
+		if err := uinputIoctl(f, uiSetKeyBit, btnTouch); err != nil {
+			return err
+		}
+		for _, axis := range []uintptr{absX, absY, absMTSlot, absMTPositionX, absMTPositionY, absMTTrackID} {
+			if err := uinputIoctl(f, uiSetAbsBit, axis); err != nil {
+				return err
+			}
+		}
+		if err := uinputIoctl(f, uiSetPropBit, inputPropDirect); err != nil {
+			return err
+		}
+		dev.Absmax[absX] = int32(desktop.Dx() - 1)
+		dev.Absmax[absY] = int32(desktop.Dy() - 1)
+		dev.Absmax[absMTPositionX] = int32(desktop.Dx() - 1)
+		dev.Absmax[absMTPositionY] = int32(desktop.Dy() - 1)
+		dev.Absmax[absMTSlot] = maxTouchContacts - 1
+		dev.Absmax[absMTTrackID] = 65535
+		return nil
+	})
+}
+
+// syncDesktop recrée pointeur et écran tactile si l'union des écrans a
+// changé depuis leur création. Les boutons et contacts en cours sont
+// relâchés par le noyau avec l'ancien périphérique. Appelé sous u.mu.
+func (u *uinputInput) syncDesktop() error {
+	desktop := u.bounds()
+	if desktop.Empty() || desktop == u.desktop {
+		return nil
+	}
+	pointer, err := createUinputPointer(desktop)
+	if err != nil {
+		return err
+	}
+	touch, err := createUinputTouch(desktop)
+	if err != nil {
+		destroyUinputDevice(pointer)
+		return err
+	}
+	destroyUinputDevice(u.pointer)
+	destroyUinputDevice(u.touch)
+	u.pointer, u.touch, u.desktop = pointer, touch, desktop
+	u.slots = [maxTouchContacts]touchSlot{}
+	return nil
+}
+
+func createUinputDevice(name string, setup func(*os.File, *uinputUserDev) error) (*os.File, error) {
+	f, err := os.OpenFile("/dev/uinput", os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("ouverture /dev/uinput: %v", err)
+	}
+
+	var dev uinputUserDev
+	copy(dev.Name[:], name)
+	dev.Bustype = 0x06 // BUS_VIRTUAL
+	dev.Vendor = 0x1
+	dev.Product = 0x1
+	dev.Version = 1
+
+	if err := setup(f, &dev); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("configuration uinput: %v", err)
+	}
+	if _, err := f.Write((*[unsafe.Sizeof(dev)]byte)(unsafe.Pointer(&dev))[:]); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("écriture uinput_user_dev: %v", err)
+	}
+	if err := uinputIoctl(f, uiDevCreate, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("création du périphérique uinput: %v", err)
+	}
+	return f, nil
+}
+
+func destroyUinputDevice(f *os.File) {
+	uinputIoctl(f, uiDevDestroy, 0)
+	f.Close()
+}
+
+func uinputIoctl(f *os.File, request, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (u *uinputInput) Name() string {
+	return "uinput"
+}
+
+func (u *uinputInput) Close() error {
+	destroyUinputDevice(u.pointer)
+	destroyUinputDevice(u.relative)
+	destroyUinputDevice(u.keyboard)
+	destroyUinputDevice(u.touch)
+	return nil
+}
+
+func (u *uinputInput) emit(f *os.File, events ...[3]int32) error {
+	now := time.Now()
+	tv := syscall.NsecToTimeval(now.UnixNano())
+	for _, e := range append(events, [3]int32{evSyn, synReport, 0}) {
+		ev := inputEvent{Time: tv, Type: uint16(e[0]), Code: uint16(e[1]), Value: e[2]}
+		if _, err := f.Write((*[unsafe.Sizeof(ev)]byte)(unsafe.Pointer(&ev))[:]); err != nil {
+			return fmt.Errorf("écriture événement uinput: %v", err)
+		}
+	}
+	return nil
+}
+
+func (u *uinputInput) Mouse(x, y int, button string, action string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	btn := int32(btnLeft)
+	if button == "right" {
+		btn = btnRight
+	} else if button == "middle" {
+		btn = btnMiddle
+	}
+	absPos := func() [][3]int32 {
+		return [][3]int32{
+			{evAbs, absX, int32(x - u.desktop.Min.X)},
+			{evAbs, absY, int32(y - u.desktop.Min.Y)},
+		}
+	}
+
+	switch action {
+	case "move", "drag":
+		if err := u.syncDesktop(); err != nil {
+			return err
+		}
+		return u.emit(u.pointer, absPos()...)
+	case "down":
+		if err := u.syncDesktop(); err != nil {
+			return err
+		}
+		return u.emit(u.pointer, append(absPos(), [3]int32{evKey, btn, 1})...)
+	case "up":
+		return u.emit(u.pointer, [3]int32{evKey, btn, 0})
+	case "scroll:1":
+		return u.emit(u.relative, [3]int32{evRel, relWheel, 1})
+	case "scroll:-1":
+		return u.emit(u.relative, [3]int32{evRel, relWheel, -1})
+	}
+	return nil
+}
+
+func (u *uinputInput) Keyboard(ev *KeyboardEvent) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	code, needShift, ok := evdevForEvent(ev)
+	if !ok {
+		return fmt.Errorf("touche non supportée: %q (%s)", ev.Key, ev.Code)
+	}
+
+	var codes []int32
+	for _, m := range []struct {
+		on   bool
+		code int32
+	}{
+		{ev.Ctrl, keyLeftCtrl},
+		{ev.Alt, keyLeftAlt},
+		{ev.Shift || needShift, keyLeftShift},
+		{ev.Meta, keyLeftMeta},
+	} {
+		if m.on && m.code != code {
+			codes = append(codes, m.code)
+		}
+	}
+	codes = append(codes, code)
+
+	if ev.Action != "up" {
+		for _, c := range codes {
+			if err := u.emit(u.keyboard, [3]int32{evKey, c, 1}); err != nil {
+				return err
+			}
+		}
+	}
+	if ev.Action != "down" {
+		for i := len(codes) - 1; i >= 0; i-- {
+			if err := u.emit(u.keyboard, [3]int32{evKey, codes[i], 0}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (u *uinputInput) Scroll(x, y, dx, dy int) error {
+	return u.SmoothScroll(x, y, dx*scrollUnitsPerNotch, dy*scrollUnitsPerNotch)
+}
+
+// SmoothScroll place le pointeur absolu sous (x, y) puis émet sur la souris
+// relative les deltas haute résolution, accompagnés comme le ferait une
+// vraie souris d'un REL_WHEEL à chaque cran complet pour les applications
+// qui ne lisent que ce dernier. REL_WHEEL positif = haut.
+func (u *uinputInput) SmoothScroll(x, y, dx, dy int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.syncDesktop(); err != nil {
+		return err
+	}
+	if err := u.emit(u.pointer, [3]int32{evAbs, absX, int32(x - u.desktop.Min.X)}, [3]int32{evAbs, absY, int32(y - u.desktop.Min.Y)}); err != nil {
+		return err
+	}
+
+	var events [][3]int32
+	if dy != 0 {
+		events = append(events, [3]int32{evRel, relWheelHiRes, int32(-dy)})
+		if notches := accumulateAxis(&u.wheelY, dy); notches != 0 {
+			events = append(events, [3]int32{evRel, relWheel, int32(-notches)})
+		}
+	}
+	if dx != 0 {
+		events = append(events, [3]int32{evRel, relHWheelHiRes, int32(dx)})
+		if notches := accumulateAxis(&u.wheelX, dx); notches != 0 {
+			events = append(events, [3]int32{evRel, relHWheel, int32(notches)})
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return u.emit(u.relative, events...)
+}
+
+type touchSlot struct {
+	active bool
+	owner  uint64
+	id     int
+	x, y   int32
+}
+
+// Touch met à jour les contacts de owner : les doigts absents de contacts
+// sont levés, les nouveaux occupent un slot libre.
+func (u *uinputInput) Touch(owner uint64, contacts []touchContact) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.syncDesktop(); err != nil {
+		return err
+	}
+	var events [][3]int32
+	wasTouching := u.touching()
+
+	for i := range u.slots {
+		slot := &u.slots[i]
+		if !slot.active || slot.owner != owner {
+			continue
+		}
+		found := false
+		for _, t := range contacts {
+			if t.ID == slot.id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			slot.active = false
+			events = append(events, [3]int32{evAbs, absMTSlot, int32(i)}, [3]int32{evAbs, absMTTrackID, -1})
+		}
+	}
+
+	for _, t := range contacts {
+		x, y := int32(t.X-u.desktop.Min.X), int32(t.Y-u.desktop.Min.Y)
+		index := -1
+		for i := range u.slots {
+			if u.slots[i].active && u.slots[i].owner == owner && u.slots[i].id == t.ID {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			for i := range u.slots {
+				if !u.slots[i].active {
+					index = i
+					break
+				}
+			}
+			if index < 0 {
+				return fmt.Errorf("plus de %d contacts simultanés", maxTouchContacts)
+			}
+			u.nextTrack = (u.nextTrack + 1) & 0xffff
+			u.slots[index] = touchSlot{active: true, owner: owner, id: t.ID, x: -1, y: -1}
+			events = append(events, [3]int32{evAbs, absMTSlot, int32(index)}, [3]int32{evAbs, absMTTrackID, u.nextTrack})
+		} else if u.slots[index].x == x && u.slots[index].y == y {
+			continue
+		} else {
+			events = append(events, [3]int32{evAbs, absMTSlot, int32(index)})
+		}
+		u.slots[index].x, u.slots[index].y = x, y
+		events = append(events, [3]int32{evAbs, absMTPositionX, x}, [3]int32{evAbs, absMTPositionY, y})
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	// Émulation mono-touch : BTN_TOUCH et ABS_X/Y suivent le premier contact
+	touching := u.touching()
+	if touching != wasTouching {
+		value := int32(0)
+		if touching {
+			value = 1
+		}
+		events = append(events, [3]int32{evKey, btnTouch, value})
+	}
+	for _, slot := range u.slots {
+		if slot.active {
+			events = append(events, [3]int32{evAbs, absX, slot.x}, [3]int32{evAbs, absY, slot.y})
+			break
+		}
+	}
+	return u.emit(u.touch, events...)
+}
+
+func (u *uinputInput) touching() bool {
+	for _, slot := range u.slots {
+		if slot.active {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *uinputInput) RelativeMouse(dx, dy int, button, action string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	btn := int32(btnLeft)
+	if button == "right" {
+		btn = btnRight
+	} else if button == "middle" {
+		btn = btnMiddle
+	}
+
+	switch action {
+	case "down":
+		return u.emit(u.relative, [3]int32{evKey, btn, 1})
+	case "up":
+		return u.emit(u.relative, [3]int32{evKey, btn, 0})
+	}
+	return u.emit(u.relative, [3]int32{evRel, relX, int32(dx)}, [3]int32{evRel, relY, int32(dy)})
+}
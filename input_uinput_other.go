@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+func newUinputInput(bounds func() image.Rectangle) (inputBackend, error) {
+	return nil, fmt.Errorf("uinput n'est disponible que sous Linux")
+}
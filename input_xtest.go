@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+	"github.com/jezek/xgb/xtest"
+)
+
+const (
+	keysymShiftL   = 0xffe1
+	keysymControlL = 0xffe3
+	keysymAltL     = 0xffe9
+	keysymSuperL   = 0xffeb
+)
+
+// remapDelay laisse aux clients X le temps de traiter le MappingNotify
+// après la réaffectation d'un keycode libre.
+const remapDelay = 20 * time.Millisecond
+
+// xtestInput injecte les événements via l'extension XTest sur une connexion
+// X unique ouverte au démarrage ($DISPLAY, y compris un Xvfb).
+type xtestInput struct {
+	mu   sync.Mutex
+	conn *xgb.Conn
+	root xproto.Window
+
+	// keysym -> keycode, et si Shift est nécessaire pour l'obtenir
+	keycodes map[uint32]xtestKey
+
+	// Keycodes sans keysym, réaffectés à la volée pour saisir les
+	// caractères absents de la disposition (€ sur un clavier US, CJK...)
+	perKeycode int
+	spare      []xproto.Keycode
+	nextSpare  int
+	remapped   map[xproto.Keycode]uint32
+}
+
+type xtestKey struct {
+	code  xproto.Keycode
+	shift bool
+}
+
+func newXTestInput() (inputBackend, error) {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, fmt.Errorf("connexion X impossible: %v", err)
+	}
+	if err := xtest.Init(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("extension XTest absente: %v", err)
+	}
+
+	x := &xtestInput{
+		conn: conn,
+		root: xproto.Setup(conn).DefaultScreen(conn).Root,
+	}
+	if err := x.loadKeyboardMapping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *xtestInput) loadKeyboardMapping() error {
+	setup := xproto.Setup(x.conn)
+	count := byte(setup.MaxKeycode - setup.MinKeycode + 1)
+	reply, err := xproto.GetKeyboardMapping(x.conn, setup.MinKeycode, count).Reply()
+	if err != nil {
+		return fmt.Errorf("lecture du mapping clavier: %v", err)
+	}
+
+	x.keycodes = make(map[uint32]xtestKey)
+	x.remapped = make(map[xproto.Keycode]uint32)
+	perKeycode := int(reply.KeysymsPerKeycode)
+	x.perKeycode = perKeycode
+	for i := 0; i < int(count); i++ {
+		code := xproto.Keycode(int(setup.MinKeycode) + i)
+		if perKeycode > 0 && allZero(reply.Keysyms[i*perKeycode:(i+1)*perKeycode]) {
+			x.spare = append(x.spare, code)
+			continue
+		}
+		// Colonnes 0 et 1 : sans et avec Shift, groupe principal
+		for col := 0; col < 2 && col < perKeycode; col++ {
+			sym := uint32(reply.Keysyms[i*perKeycode+col])
+			if sym == 0 {
+				continue
+			}
+			if _, ok := x.keycodes[sym]; !ok {
+				x.keycodes[sym] = xtestKey{code: code, shift: col == 1}
+			}
+		}
+	}
+	return nil
+}
+
+func (x *xtestInput) Name() string {
+	return "xtest"
+}
+
+func (x *xtestInput) Close() error {
+	x.conn.Close()
+	return nil
+}
+
+func (x *xtestInput) fake(eventType byte, detail byte, rootX, rootY int) error {
+	return xtest.FakeInputChecked(x.conn, eventType, detail, 0, x.root, int16(rootX), int16(rootY), 0).Check()
+}
+
+func (x *xtestInput) Mouse(posX, posY int, button string, action string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	buttonNum := byte(1)
+	if button == "right" {
+		buttonNum = 3
+	} else if button == "middle" {
+		buttonNum = 2
+	}
+
+	switch {
+	case action == "move" || action == "drag":
+		return x.fake(xproto.MotionNotify, 0, posX, posY)
+	case action == "down":
+		if err := x.fake(xproto.MotionNotify, 0, posX, posY); err != nil {
+			return err
+		}
+		return x.fake(xproto.ButtonPress, buttonNum, 0, 0)
+	case action == "up":
+		return x.fake(xproto.ButtonRelease, buttonNum, 0, 0)
+	case action == "scroll:1" || action == "scroll:-1":
+		// Boutons 4 / 5 : molette haut / bas
+		wheel := byte(4)
+		if action == "scroll:-1" {
+			wheel = 5
+		}
+		if err := x.fake(xproto.ButtonPress, wheel, 0, 0); err != nil {
+			return err
+		}
+		return x.fake(xproto.ButtonRelease, wheel, 0, 0)
+	}
+	return nil
+}
+
+func (x *xtestInput) Keyboard(ev *KeyboardEvent) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	sym, ok := keysymForEvent(ev)
+	if !ok {
+		return fmt.Errorf("touche non supportée: %q (%s)", ev.Key, ev.Code)
+	}
+	target, ok := x.keycodes[sym]
+	if !ok {
+		var err error
+		if target, err = x.remap(sym); err != nil {
+			return err
+		}
+	}
+
+	var modifiers []uint32
+	if ev.Ctrl {
+		modifiers = append(modifiers, keysymControlL)
+	}
+	if ev.Alt {
+		modifiers = append(modifiers, keysymAltL)
+	}
+	if ev.Shift || target.shift {
+		modifiers = append(modifiers, keysymShiftL)
+	}
+	if ev.Meta {
+		modifiers = append(modifiers, keysymSuperL)
+	}
+	codes := make([]xproto.Keycode, 0, len(modifiers)+1)
+	for _, m := range modifiers {
+		if k, ok := x.keycodes[m]; ok && k.code != target.code {
+			codes = append(codes, k.code)
+		}
+	}
+	codes = append(codes, target.code)
+
+	// Même sémantique que "xdotool keydown/keyup ctrl+a" : les modificateurs
+	// sont pressés avant la touche et relâchés après elle.
+	if ev.Action != "up" {
+		for _, code := range codes {
+			if err := x.fake(xproto.KeyPress, byte(code), 0, 0); err != nil {
+				return err
+			}
+		}
+	}
+	if ev.Action != "down" {
+		for i := len(codes) - 1; i >= 0; i-- {
+			if err := x.fake(xproto.KeyRelease, byte(codes[i]), 0, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func allZero(syms []xproto.Keysym) bool {
+	for _, sym := range syms {
+		if sym != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// remap affecte sym à un keycode libre, à tour de rôle : l'affectation reste
+// en place pour les frappes suivantes jusqu'à ce que le keycode soit recyclé.
+func (x *xtestInput) remap(sym uint32) (xtestKey, error) {
+	if len(x.spare) == 0 {
+		return xtestKey{}, fmt.Errorf("keysym 0x%x absent du mapping clavier et aucun keycode libre", sym)
+	}
+	code := x.spare[x.nextSpare]
+	x.nextSpare = (x.nextSpare + 1) % len(x.spare)
+
+	syms := make([]xproto.Keysym, x.perKeycode)
+	for i := range syms {
+		syms[i] = xproto.Keysym(sym)
+	}
+	if err := xproto.ChangeKeyboardMappingChecked(x.conn, 1, code, byte(x.perKeycode), syms).Check(); err != nil {
+		return xtestKey{}, fmt.Errorf("réaffectation du keycode %d: %v", code, err)
+	}
+	if old, ok := x.remapped[code]; ok {
+		delete(x.keycodes, old)
+	}
+	x.remapped[code] = sym
+	key := xtestKey{code: code}
+	x.keycodes[sym] = key
+	time.Sleep(remapDelay)
+	return key, nil
+}
+
+// Text saisit chaque caractère via son keysym, sans dépendre de la
+// disposition clavier : un keycode libre est réaffecté si besoin.
+func (x *xtestInput) Text(text string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	shift, hasShift := x.keycodes[keysymShiftL]
+	for _, r := range text {
+		sym := keysymForRune(r)
+		switch r {
+		case '\n':
+			sym = 0xff0d
+		case '\t':
+			sym = 0xff09
+		case '\r':
+			continue
+		}
+
+		target, ok := x.keycodes[sym]
+		if !ok {
+			var err error
+			if target, err = x.remap(sym); err != nil {
+				return err
+			}
+		}
+
+		if target.shift && hasShift {
+			if err := x.fake(xproto.KeyPress, byte(shift.code), 0, 0); err != nil {
+				return err
+			}
+		}
+		if err := x.fake(xproto.KeyPress, byte(target.code), 0, 0); err != nil {
+			return err
+		}
+		if err := x.fake(xproto.KeyRelease, byte(target.code), 0, 0); err != nil {
+			return err
+		}
+		if target.shift && hasShift {
+			if err := x.fake(xproto.KeyRelease, byte(shift.code), 0, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (x *xtestInput) Scroll(posX, posY, dx, dy int) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if err := x.fake(xproto.MotionNotify, 0, posX, posY); err != nil {
+		return err
+	}
+	// Boutons 4 / 5 : haut / bas, 6 / 7 : gauche / droite
+	for _, axis := range []struct{ n, less, more int }{{dy, 4, 5}, {dx, 6, 7}} {
+		button := byte(axis.more)
+		count := axis.n
+		if count < 0 {
+			button, count = byte(axis.less), -count
+		}
+		for i := 0; i < count; i++ {
+			if err := x.fake(xproto.ButtonPress, button, 0, 0); err != nil {
+				return err
+			}
+			if err := x.fake(xproto.ButtonRelease, button, 0, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (x *xtestInput) RelativeMouse(dx, dy int, button, action string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	buttonNum := byte(1)
+	if button == "right" {
+		buttonNum = 3
+	} else if button == "middle" {
+		buttonNum = 2
+	}
+
+	switch action {
+	case "down":
+		return x.fake(xproto.ButtonPress, buttonNum, 0, 0)
+	case "up":
+		return x.fake(xproto.ButtonRelease, buttonNum, 0, 0)
+	}
+	// detail = 1 : MotionNotify relatif à la position courante
+	return x.fake(xproto.MotionNotify, 1, dx, dy)
+}
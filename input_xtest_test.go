@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+// testXTest ouvre le backend XTest sur $DISPLAY, par exemple :
+//
+//	Xvfb :99 -screen 0 1280x1024x24 & DISPLAY=:99 go test -run XTest
+func testXTest(t *testing.T) *xtestInput {
+	t.Helper()
+	if os.Getenv("DISPLAY") == "" {
+		t.Skip("DISPLAY absent, lancer le test sous Xvfb")
+	}
+	backend, err := newXTestInput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	return backend.(*xtestInput)
+}
+
+func (x *xtestInput) pointer(t *testing.T) *xproto.QueryPointerReply {
+	t.Helper()
+	reply, err := xproto.QueryPointer(x.conn, x.root).Reply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func (x *xtestInput) keyDown(t *testing.T, code xproto.Keycode) bool {
+	t.Helper()
+	reply, err := xproto.QueryKeymap(x.conn).Reply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply.Keys[code/8]&(1<<(code%8)) != 0
+}
+
+func TestXTestMouse(t *testing.T) {
+	x := testXTest(t)
+
+	if err := x.Mouse(123, 45, "", "move"); err != nil {
+		t.Fatal(err)
+	}
+	if p := x.pointer(t); p.RootX != 123 || p.RootY != 45 {
+		t.Errorf("pointeur en (%d, %d), attendu (123, 45)", p.RootX, p.RootY)
+	}
+
+	if err := x.RelativeMouse(10, -5, "", "move"); err != nil {
+		t.Fatal(err)
+	}
+	if p := x.pointer(t); p.RootX != 133 || p.RootY != 40 {
+		t.Errorf("pointeur en (%d, %d) après déplacement relatif, attendu (133, 40)", p.RootX, p.RootY)
+	}
+
+	if err := x.Mouse(200, 100, "right", "down"); err != nil {
+		t.Fatal(err)
+	}
+	p := x.pointer(t)
+	if p.RootX != 200 || p.RootY != 100 || p.Mask&xproto.ButtonMask3 == 0 {
+		t.Errorf("bouton droit: pointeur (%d, %d), masque 0x%x", p.RootX, p.RootY, p.Mask)
+	}
+	if err := x.Mouse(200, 100, "right", "up"); err != nil {
+		t.Fatal(err)
+	}
+	if p := x.pointer(t); p.Mask&xproto.ButtonMask3 != 0 {
+		t.Errorf("bouton droit encore enfoncé, masque 0x%x", p.Mask)
+	}
+}
+
+func TestXTestKeyboard(t *testing.T) {
+	x := testXTest(t)
+
+	ev := &KeyboardEvent{Key: "a", Code: "KeyA", Action: "down", Ctrl: true}
+	if err := x.Keyboard(ev); err != nil {
+		t.Fatal(err)
+	}
+	target, ctrl := x.keycodes['a'], x.keycodes[keysymControlL]
+	if !x.keyDown(t, target.code) || !x.keyDown(t, ctrl.code) {
+		t.Error("ctrl+a devrait être enfoncé")
+	}
+	ev.Action = "up"
+	if err := x.Keyboard(ev); err != nil {
+		t.Fatal(err)
+	}
+	if x.keyDown(t, target.code) || x.keyDown(t, ctrl.code) {
+		t.Error("ctrl+a devrait être relâché")
+	}
+}
+
+// Un caractère absent de la disposition est saisi via un keycode libre
+// réaffecté, qui reste relâché après la saisie.
+func TestXTestTextRemap(t *testing.T) {
+	x := testXTest(t)
+
+	sym := keysymForRune('€')
+	if _, ok := x.keycodes[sym]; ok {
+		t.Skip("€ déjà présent dans la disposition")
+	}
+	if len(x.spare) == 0 {
+		t.Skip("aucun keycode libre sur ce serveur X")
+	}
+	if err := x.Text("€"); err != nil {
+		t.Fatal(err)
+	}
+	key, ok := x.keycodes[sym]
+	if !ok {
+		t.Fatalf("keysym 0x%x non affecté", sym)
+	}
+	reply, err := xproto.GetKeyboardMapping(x.conn, key.code, 1).Reply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Keysyms) == 0 || uint32(reply.Keysyms[0]) != sym {
+		t.Errorf("keycode %d: mapping %v, attendu 0x%x", key.code, reply.Keysyms, sym)
+	}
+	if x.keyDown(t, key.code) {
+		t.Errorf("keycode %d encore enfoncé", key.code)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Nombre d'événements d'entrée en attente par client : au-delà, les
+// événements sont refusés plutôt que de retarder le curseur de plusieurs
+// secondes. Les relâchements disposent d'une réserve supplémentaire, pour
+// ne jamais laisser une touche enfoncée.
+const (
+	inputQueueSize    = 256
+	inputQueueReserve = 256
+)
+
+type queuedInput struct {
+	eventType string
+	event     validator
+}
+
+// inputQueue sépare la lecture websocket de l'injection. Les déplacements
+// consécutifs sont fusionnés en un seul vers la dernière position ; les
+// appuis de touches et de boutons restent dans l'ordre, jamais fusionnés.
+type inputQueue struct {
+	mu     sync.Mutex
+	events []queuedInput
+	ready  chan struct{}
+}
+
+func newInputQueue() *inputQueue {
+	return &inputQueue{ready: make(chan struct{}, 1)}
+}
+
+// coalesce fusionne in avec le dernier événement en attente, si possible.
+func (q *inputQueue) coalesce(in queuedInput) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n := len(q.events); n > 0 && q.events[n-1].eventType == in.eventType {
+		if merged, ok := coalesceInput(q.events[n-1].event, in.event); ok {
+			q.events[n-1].event = merged
+			return true
+		}
+	}
+	return false
+}
+
+// push ajoute in en fin de file, ou renvoie false si la file est pleine.
+// reserved autorise la réserve des relâchements.
+func (q *inputQueue) push(in queuedInput, reserved bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	limit := inputQueueSize
+	if reserved {
+		limit += inputQueueReserve
+	}
+	if len(q.events) >= limit {
+		return false
+	}
+	q.events = append(q.events, in)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (q *inputQueue) pop() (queuedInput, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.events) == 0 {
+		return queuedInput{}, false
+	}
+	in := q.events[0]
+	q.events[0] = queuedInput{}
+	q.events = q.events[1:]
+	return in, true
+}
+
+func (q *inputQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.events)
+}
+
+// coalesceInput fusionne next dans prev quand seul le résultat final compte :
+// déplacements absolus et tactiles (dernière position), relatifs et
+// défilements (deltas cumulés).
+func coalesceInput(prev, next validator) (validator, bool) {
+	switch p := prev.(type) {
+	case *MouseEvent:
+		n := next.(*MouseEvent)
+		if (p.Action == "move" || p.Action == "drag") && n.Action == p.Action && n.Button == p.Button {
+			return n, true
+		}
+	case *RelativeMouseEvent:
+		n := next.(*RelativeMouseEvent)
+		if p.Action == "move" && n.Action == "move" {
+			return &RelativeMouseEvent{DX: p.DX + n.DX, DY: p.DY + n.DY, Action: "move"}, true
+		}
+	case *TouchEvent:
+		n := next.(*TouchEvent)
+		if p.Action == "move" && n.Action == "move" && len(p.Touches) == len(n.Touches) {
+			return n, true
+		}
+	case *ScrollEvent:
+		n := next.(*ScrollEvent)
+		if p.DeltaMode == n.DeltaMode {
+			merged := *n
+			merged.DeltaX += p.DeltaX
+			merged.DeltaY += p.DeltaY
+			return &merged, true
+		}
+	}
+	return nil, false
+}
+
+// releaseInput indique les événements qui relâchent ce qui est enfoncé :
+// touche ou bouton levé, doigts retirés, contrôle retiré, "release". Ils
+// échappent au débit et utilisent la réserve de la file.
+func releaseInput(event validator) bool {
+	switch e := event.(type) {
+	case *MouseEvent:
+		return e.Action == "up"
+	case *RelativeMouseEvent:
+		return e.Action == "up"
+	case *KeyboardEvent:
+		return e.Action == "up"
+	case *TouchEvent:
+		return e.Action == "end" || e.Action == "cancel"
+	case *ControlStateEvent:
+		return !e.Enabled
+	case *ReleaseEvent:
+		return true
+	}
+	return false
+}
+
+// rateLimiter est un seau à jetons : rate événements par seconde en
+// moyenne, avec des rafales jusqu'à une seconde de débit.
+type rateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate int) *rateLimiter {
+	return &rateLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+func (l *rateLimiter) allow() bool {
+	if l.rate <= 0 {
+		return true
+	}
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// queueInput est appelé par la goroutine de lecture pour chaque événement
+// d'entrée décodé. Un événement fusionné ne consomme pas de jeton ; les
+// autres sont soumis au débit et à la taille de la file, sauf les
+// relâchements.
+func (s *ScreenStreamer) queueInput(c *client, eventType string, event validator) error {
+	in := queuedInput{eventType: eventType, event: event}
+	if c.input.coalesce(in) {
+		c.inputCoalesced.Add(1)
+		return nil
+	}
+
+	release := releaseInput(event)
+	if !release && !c.limiter.allow() {
+		s.dropInput(c, eventType, fmt.Sprintf("débit d'entrée dépassé (%d événements/s max)", s.inputRate))
+		return nil
+	}
+	if !c.input.push(in, release) {
+		s.dropInput(c, eventType, "file d'entrée pleine")
+		return nil
+	}
+	return nil
+}
+
+// dropInput compte un événement refusé et le signale au navigateur par une
+// erreur "rate_limited", une fois par intervalle au plus.
+func (s *ScreenStreamer) dropInput(c *client, eventType, reason string) {
+	c.inputDropped.Add(1)
+	if time.Since(c.lastThrottleReport) < failureReportInterval {
+		return
+	}
+	c.lastThrottleReport = time.Now()
+	log.Printf("Client %d: %s, événement %q ignoré", c.id, reason, eventType)
+	c.sendEvent("error", ErrorEvent{Code: errRateLimited, Event: eventType, Message: reason + ", événement ignoré"})
+}
+
+// inputPump injecte les événements d'un client un par un, dans l'ordre.
+// À la déconnexion, ce qui reste en file est abandonné et tout ce qui est
+// encore enfoncé est relâché.
+func (s *ScreenStreamer) inputPump(c *client) {
+	defer s.releaseInputs(c, "déconnexion")
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.input.ready:
+		}
+
+		for {
+			select {
+			case <-c.done:
+				return
+			default:
+			}
+			in, ok := c.input.pop()
+			if !ok {
+				break
+			}
+			s.injectInput(c, in)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testInputClient(t *testing.T, rate int) (*ScreenStreamer, *client) {
+	t.Helper()
+	s := NewScreenStreamer(&syntheticCapturer{})
+	s.inputRate = rate
+	c := newClient(nil, 0)
+	c.limiter = newRateLimiter(rate)
+	return s, c
+}
+
+func queued(c *client) []queuedInput {
+	var events []queuedInput
+	for {
+		in, ok := c.input.pop()
+		if !ok {
+			return events
+		}
+		events = append(events, in)
+	}
+}
+
+func TestQueueInputCoalesce(t *testing.T) {
+	s, c := testInputClient(t, 0)
+	events := []struct {
+		eventType string
+		event     validator
+	}{
+		{"mouse", &MouseEvent{X: 1, Y: 1, Action: "move"}},
+		{"mouse", &MouseEvent{X: 2, Y: 2, Action: "move"}},
+		{"mouse", &MouseEvent{X: 3, Y: 3, Action: "move"}},
+		{"mouse", &MouseEvent{X: 3, Y: 3, Button: "left", Action: "down"}},
+		{"mouse", &MouseEvent{X: 4, Y: 4, Button: "left", Action: "drag"}},
+		{"mouse", &MouseEvent{X: 5, Y: 5, Button: "left", Action: "drag"}},
+		{"mouse", &MouseEvent{X: 5, Y: 5, Button: "left", Action: "up"}},
+		{"scroll", &ScrollEvent{X: 5, Y: 5, DeltaY: 40}},
+		{"scroll", &ScrollEvent{X: 6, Y: 6, DeltaY: 60, DeltaX: -10}},
+		{"scroll", &ScrollEvent{X: 6, Y: 6, DeltaY: 1, DeltaMode: 1}},
+		{"mouseRelative", &RelativeMouseEvent{DX: 3, DY: -1, Action: "move"}},
+		{"mouseRelative", &RelativeMouseEvent{DX: 2, DY: -4, Action: "move"}},
+	}
+	for _, e := range events {
+		if err := s.queueInput(c, e.eventType, e.event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := queued(c)
+	want := []string{"move 3,3", "down 3,3", "drag 5,5", "up 5,5", "scroll 6,6 -10,100 mode 0", "scroll 6,6 0,1 mode 1", "relative 5,-5"}
+	if len(got) != len(want) {
+		t.Fatalf("%d événement(s) en file, attendu %d", len(got), len(want))
+	}
+	for i, in := range got {
+		var desc string
+		switch ev := in.event.(type) {
+		case *MouseEvent:
+			desc = fmt.Sprintf("%s %d,%d", ev.Action, ev.X, ev.Y)
+		case *ScrollEvent:
+			desc = fmt.Sprintf("scroll %d,%d %v,%v mode %d", ev.X, ev.Y, ev.DeltaX, ev.DeltaY, ev.DeltaMode)
+		case *RelativeMouseEvent:
+			desc = fmt.Sprintf("relative %d,%d", ev.DX, ev.DY)
+		}
+		if desc != want[i] {
+			t.Errorf("événement %d: %s, attendu %s", i, desc, want[i])
+		}
+	}
+	if n := c.inputCoalesced.Load(); n != 5 {
+		t.Errorf("%d événement(s) fusionnés, attendu 5", n)
+	}
+}
+
+// Au-delà du débit, appuis et texte sont refusés ; les relâchements passent.
+func TestQueueInputRateLimit(t *testing.T) {
+	s, c := testInputClient(t, 5)
+	for i := 0; i < 8; i++ {
+		s.queueInput(c, "keyboard", &KeyboardEvent{Key: "a", Code: "KeyA", Action: "down"})
+	}
+	s.queueInput(c, "text", &TextEvent{Text: "abc"})
+	s.queueInput(c, "mouse", &MouseEvent{Button: "left", Action: "down"})
+	s.queueInput(c, "keyboard", &KeyboardEvent{Key: "a", Code: "KeyA", Action: "up"})
+	s.queueInput(c, "mouse", &MouseEvent{Button: "left", Action: "up"})
+	s.queueInput(c, "release", &ReleaseEvent{})
+
+	if n := c.input.len(); n != 5+3 {
+		t.Errorf("%d événement(s) en file, attendu 8", n)
+	}
+	if n := c.inputDropped.Load(); n != 5 {
+		t.Errorf("%d événement(s) refusés, attendu 5", n)
+	}
+	if len(c.control) != 1 {
+		t.Errorf("%d erreur(s) envoyées, attendu une seule par intervalle", len(c.control))
+	}
+}
+
+func TestQueueInputFull(t *testing.T) {
+	s, c := testInputClient(t, 0)
+	for i := 0; i < inputQueueSize; i++ {
+		s.queueInput(c, "keyboard", &KeyboardEvent{Key: "a", Code: "KeyA", Action: "down"})
+	}
+	s.queueInput(c, "keyboard", &KeyboardEvent{Key: "b", Code: "KeyB", Action: "down"})
+	s.queueInput(c, "text", &TextEvent{Text: "x"})
+	s.queueInput(c, "mouse", &MouseEvent{X: 1, Y: 1, Action: "move"})
+	if n := c.input.len(); n != inputQueueSize {
+		t.Fatalf("%d événement(s) en file, attendu %d", n, inputQueueSize)
+	}
+
+	// Les relâchements utilisent la réserve, bornée elle aussi
+	for i := 0; i < inputQueueReserve+10; i++ {
+		s.queueInput(c, "keyboard", &KeyboardEvent{Key: "a", Code: "KeyA", Action: "up"})
+	}
+	if n := c.input.len(); n != inputQueueSize+inputQueueReserve {
+		t.Errorf("%d événement(s) en file, attendu %d", n, inputQueueSize+inputQueueReserve)
+	}
+	if n := c.inputDropped.Load(); n != 3+10 {
+		t.Errorf("%d événement(s) refusés, attendu 13", n)
+	}
+}
+
+func TestQueueInputKeepsOrder(t *testing.T) {
+	s, c := testInputClient(t, 0)
+	var want []string
+	for i := 0; i < 20; i++ {
+		key := string(rune('a' + i))
+		for _, action := range []string{"down", "up"} {
+			s.queueInput(c, "keyboard", &KeyboardEvent{Key: key, Action: action})
+			want = append(want, key+" "+action)
+		}
+		s.queueInput(c, "mouse", &MouseEvent{X: i, Y: i, Action: "move"})
+		want = append(want, "move")
+	}
+
+	got := queued(c)
+	if len(got) != len(want) {
+		t.Fatalf("%d événement(s) en file, attendu %d", len(got), len(want))
+	}
+	for i, in := range got {
+		desc := "move"
+		if ev, ok := in.event.(*KeyboardEvent); ok {
+			desc = ev.Key + " " + ev.Action
+		}
+		if desc != want[i] {
+			t.Errorf("événement %d: %s, attendu %s", i, desc, want[i])
+		}
+	}
+}
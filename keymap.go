@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Codes evdev des modificateurs (linux/input-event-codes.h)
+const (
+	keyLeftCtrl  = 29
+	keyLeftShift = 42
+	keyLeftAlt   = 56
+	keyLeftMeta  = 125
+)
+
+// keyDef décrit une touche : son KeyboardEvent.code (position physique),
+// son KeyboardEvent.key, le keysym X11, le code evdev (uinput) et le nom
+// de keysym passé à xdotool. Pour les touches de caractère, key et shifted
+// sont les caractères d'un clavier US sans et avec Shift.
+type keyDef struct {
+	code    string
+	key     string
+	shifted string
+	keysym  uint32
+	evdev   int32
+	name    string
+}
+
+var keyTable = []keyDef{
+	// Lettres
+	{"KeyA", "a", "A", 'a', 30, "a"},
+	{"KeyB", "b", "B", 'b', 48, "b"},
+	{"KeyC", "c", "C", 'c', 46, "c"},
+	{"KeyD", "d", "D", 'd', 32, "d"},
+	{"KeyE", "e", "E", 'e', 18, "e"},
+	{"KeyF", "f", "F", 'f', 33, "f"},
+	{"KeyG", "g", "G", 'g', 34, "g"},
+	{"KeyH", "h", "H", 'h', 35, "h"},
+	{"KeyI", "i", "I", 'i', 23, "i"},
+	{"KeyJ", "j", "J", 'j', 36, "j"},
+	{"KeyK", "k", "K", 'k', 37, "k"},
+	{"KeyL", "l", "L", 'l', 38, "l"},
+	{"KeyM", "m", "M", 'm', 50, "m"},
+	{"KeyN", "n", "N", 'n', 49, "n"},
+	{"KeyO", "o", "O", 'o', 24, "o"},
+	{"KeyP", "p", "P", 'p', 25, "p"},
+	{"KeyQ", "q", "Q", 'q', 16, "q"},
+	{"KeyR", "r", "R", 'r', 19, "r"},
+	{"KeyS", "s", "S", 's', 31, "s"},
+	{"KeyT", "t", "T", 't', 20, "t"},
+	{"KeyU", "u", "U", 'u', 22, "u"},
+	{"KeyV", "v", "V", 'v', 47, "v"},
+	{"KeyW", "w", "W", 'w', 17, "w"},
+	{"KeyX", "x", "X", 'x', 45, "x"},
+	{"KeyY", "y", "Y", 'y', 21, "y"},
+	{"KeyZ", "z", "Z", 'z', 44, "z"},
+
+	// Chiffres et ponctuation
+	{"Digit1", "1", "!", '1', 2, "1"},
+	{"Digit2", "2", "@", '2', 3, "2"},
+	{"Digit3", "3", "#", '3', 4, "3"},
+	{"Digit4", "4", "$", '4', 5, "4"},
+	{"Digit5", "5", "%", '5', 6, "5"},
+	{"Digit6", "6", "^", '6', 7, "6"},
+	{"Digit7", "7", "&", '7', 8, "7"},
+	{"Digit8", "8", "*", '8', 9, "8"},
+	{"Digit9", "9", "(", '9', 10, "9"},
+	{"Digit0", "0", ")", '0', 11, "0"},
+	{"Minus", "-", "_", '-', 12, "minus"},
+	{"Equal", "=", "+", '=', 13, "equal"},
+	{"BracketLeft", "[", "{", '[', 26, "bracketleft"},
+	{"BracketRight", "]", "}", ']', 27, "bracketright"},
+	{"Semicolon", ";", ":", ';', 39, "semicolon"},
+	{"Quote", "'", "\"", '\'', 40, "apostrophe"},
+	{"Backquote", "`", "~", '`', 41, "grave"},
+	{"Backslash", "\\", "|", '\\', 43, "backslash"},
+	{"Comma", ",", "<", ',', 51, "comma"},
+	{"Period", ".", ">", '.', 52, "period"},
+	{"Slash", "/", "?", '/', 53, "slash"},
+	{"IntlBackslash", "", "", '<', 86, "less"},
+	{"Space", " ", "", ' ', 57, "space"},
+
+	// Édition et navigation
+	{"Enter", "Enter", "", 0xff0d, 28, "Return"},
+	{"Tab", "Tab", "", 0xff09, 15, "Tab"},
+	{"Backspace", "Backspace", "", 0xff08, 14, "BackSpace"},
+	{"Escape", "Escape", "", 0xff1b, 1, "Escape"},
+	{"Delete", "Delete", "", 0xffff, 111, "Delete"},
+	{"Insert", "Insert", "", 0xff63, 110, "Insert"},
+	{"Home", "Home", "", 0xff50, 102, "Home"},
+	{"End", "End", "", 0xff57, 107, "End"},
+	{"PageUp", "PageUp", "", 0xff55, 104, "Prior"},
+	{"PageDown", "PageDown", "", 0xff56, 109, "Next"},
+	{"ArrowLeft", "ArrowLeft", "", 0xff51, 105, "Left"},
+	{"ArrowUp", "ArrowUp", "", 0xff52, 103, "Up"},
+	{"ArrowRight", "ArrowRight", "", 0xff53, 106, "Right"},
+	{"ArrowDown", "ArrowDown", "", 0xff54, 108, "Down"},
+	{"CapsLock", "CapsLock", "", 0xffe5, 58, "Caps_Lock"},
+	{"NumLock", "NumLock", "", 0xff7f, 69, "Num_Lock"},
+	{"ScrollLock", "ScrollLock", "", 0xff14, 70, "Scroll_Lock"},
+	{"Pause", "Pause", "", 0xff13, 119, "Pause"},
+	{"PrintScreen", "PrintScreen", "", 0xff61, 99, "Print"},
+	{"ContextMenu", "ContextMenu", "", 0xff67, 127, "Menu"},
+
+	// Modificateurs
+	{"ShiftLeft", "Shift", "", keysymShiftL, keyLeftShift, "Shift_L"},
+	{"ShiftRight", "Shift", "", 0xffe2, 54, "Shift_R"},
+	{"ControlLeft", "Control", "", keysymControlL, keyLeftCtrl, "Control_L"},
+	{"ControlRight", "Control", "", 0xffe4, 97, "Control_R"},
+	{"AltLeft", "Alt", "", keysymAltL, keyLeftAlt, "Alt_L"},
+	{"AltRight", "AltGraph", "", 0xfe03, 100, "ISO_Level3_Shift"},
+	{"MetaLeft", "Meta", "", keysymSuperL, keyLeftMeta, "Super_L"},
+	{"MetaRight", "Meta", "", 0xffec, 126, "Super_R"},
+
+	// Touches de fonction
+	{"F1", "F1", "", 0xffbe, 59, "F1"},
+	{"F2", "F2", "", 0xffbf, 60, "F2"},
+	{"F3", "F3", "", 0xffc0, 61, "F3"},
+	{"F4", "F4", "", 0xffc1, 62, "F4"},
+	{"F5", "F5", "", 0xffc2, 63, "F5"},
+	{"F6", "F6", "", 0xffc3, 64, "F6"},
+	{"F7", "F7", "", 0xffc4, 65, "F7"},
+	{"F8", "F8", "", 0xffc5, 66, "F8"},
+	{"F9", "F9", "", 0xffc6, 67, "F9"},
+	{"F10", "F10", "", 0xffc7, 68, "F10"},
+	{"F11", "F11", "", 0xffc8, 87, "F11"},
+	{"F12", "F12", "", 0xffc9, 88, "F12"},
+
+	// Pavé numérique
+	{"Numpad0", "0", "", 0xffb0, 82, "KP_0"},
+	{"Numpad1", "1", "", 0xffb1, 79, "KP_1"},
+	{"Numpad2", "2", "", 0xffb2, 80, "KP_2"},
+	{"Numpad3", "3", "", 0xffb3, 81, "KP_3"},
+	{"Numpad4", "4", "", 0xffb4, 75, "KP_4"},
+	{"Numpad5", "5", "", 0xffb5, 76, "KP_5"},
+	{"Numpad6", "6", "", 0xffb6, 77, "KP_6"},
+	{"Numpad7", "7", "", 0xffb7, 71, "KP_7"},
+	{"Numpad8", "8", "", 0xffb8, 72, "KP_8"},
+	{"Numpad9", "9", "", 0xffb9, 73, "KP_9"},
+	{"NumpadAdd", "+", "", 0xffab, 78, "KP_Add"},
+	{"NumpadSubtract", "-", "", 0xffad, 74, "KP_Subtract"},
+	{"NumpadMultiply", "*", "", 0xffaa, 55, "KP_Multiply"},
+	{"NumpadDivide", "/", "", 0xffaf, 98, "KP_Divide"},
+	{"NumpadDecimal", ".", "", 0xffae, 83, "KP_Decimal"},
+	{"NumpadEqual", "=", "", 0xffbd, 117, "KP_Equal"},
+	{"NumpadEnter", "Enter", "", 0xff8d, 96, "KP_Enter"},
+
+	// Multimédia
+	{"AudioVolumeMute", "AudioVolumeMute", "", 0x1008ff12, 113, "XF86AudioMute"},
+	{"AudioVolumeDown", "AudioVolumeDown", "", 0x1008ff11, 114, "XF86AudioLowerVolume"},
+	{"AudioVolumeUp", "AudioVolumeUp", "", 0x1008ff13, 115, "XF86AudioRaiseVolume"},
+	{"MediaPlayPause", "MediaPlayPause", "", 0x1008ff14, 164, "XF86AudioPlay"},
+	{"MediaStop", "MediaStop", "", 0x1008ff15, 166, "XF86AudioStop"},
+	{"MediaTrackPrevious", "MediaTrackPrevious", "", 0x1008ff16, 165, "XF86AudioPrev"},
+	{"MediaTrackNext", "MediaTrackNext", "", 0x1008ff17, 163, "XF86AudioNext"},
+}
+
+// Index de keyTable ; en cas de doublon, la première entrée l'emporte
+// (ex: "1" désigne Digit1 et non Numpad1).
+var keysByCode, keysByKey, keysByShifted = indexKeyTable()
+
+func indexKeyTable() (byCode, byKey, byShifted map[string]keyDef) {
+	byCode = make(map[string]keyDef)
+	byKey = make(map[string]keyDef)
+	byShifted = make(map[string]keyDef)
+	for _, def := range keyTable {
+		if _, ok := byCode[def.code]; !ok {
+			byCode[def.code] = def
+		}
+		if _, ok := byKey[def.key]; !ok && def.key != "" {
+			byKey[def.key] = def
+		}
+		if _, ok := byShifted[def.shifted]; !ok && def.shifted != "" {
+			byShifted[def.shifted] = def
+		}
+	}
+	return byCode, byKey, byShifted
+}
+
+// printableRune renvoie le caractère produit par la touche, si key en est un.
+func printableRune(key string) (rune, bool) {
+	r, size := utf8.DecodeRuneInString(key)
+	if r == utf8.RuneError || size != len(key) || !unicode.IsPrint(r) {
+		return 0, false
+	}
+	return r, true
+}
+
+// namedKey trouve une touche non imprimable (flèches, F1, Shift...). code
+// départage les variantes gauche/droite et pavé numérique, tant qu'il
+// désigne bien la même touche que key (Numpad8 sans NumLock = ArrowUp).
+func namedKey(ev *KeyboardEvent) (keyDef, bool) {
+	if def, ok := keysByCode[ev.Code]; ok && def.key == ev.Key {
+		return def, true
+	}
+	def, ok := keysByKey[ev.Key]
+	return def, ok
+}
+
+func keysymForRune(r rune) uint32 {
+	// Latin-1 : le keysym est le code du caractère ; au-delà, keysym Unicode
+	if r < 0x100 {
+		return uint32(r)
+	}
+	return 0x01000000 | uint32(r)
+}
+
+// keysymForEvent traduit une touche en keysym X11. Les caractères sont
+// traduits d'après key, ce qui respecte la disposition du navigateur.
+func keysymForEvent(ev *KeyboardEvent) (uint32, bool) {
+	if r, ok := printableRune(ev.Key); ok {
+		return keysymForRune(r), true
+	}
+	if def, ok := namedKey(ev); ok {
+		return def.keysym, true
+	}
+	if def, ok := keysByCode[ev.Code]; ok {
+		return def.keysym, true
+	}
+	return 0, false
+}
+
+// evdevForEvent traduit une touche en code evdev. uinput émule un clavier
+// physique : code est prioritaire, key ne sert qu'en l'absence de code (on
+// suppose alors une disposition US, shift indique si Shift est requis).
+func evdevForEvent(ev *KeyboardEvent) (code int32, shift bool, ok bool) {
+	if def, ok := keysByCode[ev.Code]; ok {
+		return def.evdev, false, true
+	}
+	if _, printable := printableRune(ev.Key); !printable {
+		if def, ok := namedKey(ev); ok {
+			return def.evdev, false, true
+		}
+		return 0, false, false
+	}
+	if def, ok := keysByKey[ev.Key]; ok {
+		return def.evdev, false, true
+	}
+	if def, ok := keysByShifted[ev.Key]; ok {
+		return def.evdev, true, true
+	}
+	return 0, false, false
+}
+
+// xdotoolKeyName renvoie le nom de keysym à passer à xdotool.
+func xdotoolKeyName(ev *KeyboardEvent) (string, bool) {
+	if r, ok := printableRune(ev.Key); ok {
+		if r < 0x80 && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return ev.Key, true
+		}
+		return fmt.Sprintf("0x%x", keysymForRune(r)), true
+	}
+	if def, ok := namedKey(ev); ok {
+		return def.name, true
+	}
+	if def, ok := keysByCode[ev.Code]; ok {
+		return def.name, true
+	}
+	return "", false
+}
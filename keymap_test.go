@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+type keyRef struct {
+	keysym uint32
+	evdev  int32
+}
+
+// keyReference recopie, indépendamment de keyTable, les valeurs de
+// X11/keysymdef.h, XF86keysym.h et linux/input-event-codes.h.
+func keyReference() map[string]keyRef {
+	ref := map[string]keyRef{
+		"Minus":         {'-', 12}, // KEY_MINUS
+		"Equal":         {'=', 13}, // KEY_EQUAL
+		"BracketLeft":   {'[', 26}, // KEY_LEFTBRACE
+		"BracketRight":  {']', 27}, // KEY_RIGHTBRACE
+		"Semicolon":     {';', 39}, // KEY_SEMICOLON
+		"Quote":         {'\'', 40},
+		"Backquote":     {'`', 41},  // KEY_GRAVE
+		"Backslash":     {'\\', 43}, // KEY_BACKSLASH
+		"Comma":         {',', 51},
+		"Period":        {'.', 52}, // KEY_DOT
+		"Slash":         {'/', 53},
+		"IntlBackslash": {0x003c, 86}, // XK_less, KEY_102ND
+		"Space":         {0x0020, 57},
+
+		"Enter":       {0xff0d, 28}, // XK_Return
+		"Tab":         {0xff09, 15},
+		"Backspace":   {0xff08, 14},
+		"Escape":      {0xff1b, 1},
+		"Delete":      {0xffff, 111},
+		"Insert":      {0xff63, 110},
+		"Home":        {0xff50, 102},
+		"End":         {0xff57, 107},
+		"PageUp":      {0xff55, 104}, // XK_Prior
+		"PageDown":    {0xff56, 109}, // XK_Next
+		"ArrowLeft":   {0xff51, 105},
+		"ArrowUp":     {0xff52, 103},
+		"ArrowRight":  {0xff53, 106},
+		"ArrowDown":   {0xff54, 108},
+		"CapsLock":    {0xffe5, 58},
+		"NumLock":     {0xff7f, 69},
+		"ScrollLock":  {0xff14, 70},
+		"Pause":       {0xff13, 119},
+		"PrintScreen": {0xff61, 99},  // XK_Print, KEY_SYSRQ
+		"ContextMenu": {0xff67, 127}, // XK_Menu, KEY_COMPOSE
+
+		"ShiftLeft":    {0xffe1, 42},
+		"ShiftRight":   {0xffe2, 54},
+		"ControlLeft":  {0xffe3, 29},
+		"ControlRight": {0xffe4, 97},
+		"AltLeft":      {0xffe9, 56},
+		"AltRight":     {0xfe03, 100}, // XK_ISO_Level3_Shift
+		"MetaLeft":     {0xffeb, 125}, // XK_Super_L
+		"MetaRight":    {0xffec, 126},
+
+		"F11": {0xffc8, 87},
+		"F12": {0xffc9, 88},
+
+		"NumpadAdd":      {0xffab, 78},
+		"NumpadSubtract": {0xffad, 74},
+		"NumpadMultiply": {0xffaa, 55},
+		"NumpadDivide":   {0xffaf, 98},
+		"NumpadDecimal":  {0xffae, 83},
+		"NumpadEqual":    {0xffbd, 117},
+		"NumpadEnter":    {0xff8d, 96},
+
+		"AudioVolumeMute":    {0x1008ff12, 113},
+		"AudioVolumeDown":    {0x1008ff11, 114},
+		"AudioVolumeUp":      {0x1008ff13, 115},
+		"MediaPlayPause":     {0x1008ff14, 164},
+		"MediaStop":          {0x1008ff15, 166}, // KEY_STOPCD
+		"MediaTrackPrevious": {0x1008ff16, 165},
+		"MediaTrackNext":     {0x1008ff17, 163},
+	}
+
+	// Lettres : codes evdev consécutifs par rangée du clavier QWERTY
+	for row, start := range map[string]int32{"qwertyuiop": 16, "asdfghjkl": 30, "zxcvbnm": 44} {
+		for i, r := range row {
+			ref[fmt.Sprintf("Key%c", r-'a'+'A')] = keyRef{uint32(r), start + int32(i)}
+		}
+	}
+	// Chiffres : KEY_1 = 2 ... KEY_9 = 10, KEY_0 = 11
+	for d := 1; d <= 9; d++ {
+		ref[fmt.Sprintf("Digit%d", d)] = keyRef{uint32('0' + d), int32(d + 1)}
+	}
+	ref["Digit0"] = keyRef{'0', 11}
+	// F1 à F10 : KEY_F1 = 59 ... KEY_F10 = 68
+	for f := 1; f <= 10; f++ {
+		ref[fmt.Sprintf("F%d", f)] = keyRef{0xffbe + uint32(f-1), int32(58 + f)}
+	}
+	// Pavé numérique : XK_KP_0 = 0xffb0..., codes evdev par rangée
+	numpad := map[int]int32{7: 71, 8: 72, 9: 73, 4: 75, 5: 76, 6: 77, 1: 79, 2: 80, 3: 81, 0: 82}
+	for d, evdev := range numpad {
+		ref[fmt.Sprintf("Numpad%d", d)] = keyRef{0xffb0 + uint32(d), evdev}
+	}
+	return ref
+}
+
+func TestKeyTableEntries(t *testing.T) {
+	ref := keyReference()
+	seen := make(map[string]bool)
+	for _, def := range keyTable {
+		t.Run(def.code, func(t *testing.T) {
+			if seen[def.code] {
+				t.Fatalf("code %s en double dans keyTable", def.code)
+			}
+			seen[def.code] = true
+
+			want, ok := ref[def.code]
+			if !ok {
+				t.Fatalf("code %s absent de la référence", def.code)
+			}
+			if def.keysym != want.keysym {
+				t.Errorf("keysym 0x%x, attendu 0x%x", def.keysym, want.keysym)
+			}
+			if def.evdev != want.evdev {
+				t.Errorf("evdev %d, attendu %d", def.evdev, want.evdev)
+			}
+
+			// Traduction d'un événement navigateur portant ce key et ce code
+			ev := &KeyboardEvent{Key: def.key, Code: def.code, Action: "press"}
+			if ev.Key == "" {
+				ev.Key = "Unidentified"
+			}
+			evdev, shift, ok := evdevForEvent(ev)
+			if !ok || evdev != want.evdev || shift {
+				t.Errorf("evdevForEvent = %d, %v, %v ; attendu %d", evdev, shift, ok, want.evdev)
+			}
+			wantKeysym := want.keysym
+			if r, printable := printableRune(ev.Key); printable {
+				// Caractère : keysym d'après key, selon la disposition du navigateur
+				wantKeysym = keysymForRune(r)
+			}
+			if keysym, ok := keysymForEvent(ev); !ok || keysym != wantKeysym {
+				t.Errorf("keysymForEvent = 0x%x, %v ; attendu 0x%x", keysym, ok, wantKeysym)
+			}
+			if name, ok := xdotoolKeyName(ev); !ok || name == "" {
+				t.Errorf("xdotoolKeyName vide")
+			}
+		})
+	}
+	for code := range ref {
+		if !seen[code] {
+			t.Errorf("code %s de la référence absent de keyTable", code)
+		}
+	}
+}
+
+// Sans code (navigateurs anciens), uinput retrouve la touche d'après key en
+// supposant un clavier US, avec Shift pour les caractères du niveau 2. Un
+// caractère aussi produit sans Shift ("*", "+" du pavé numérique) prend
+// cette touche-là.
+func TestEvdevForShiftedKey(t *testing.T) {
+	for _, def := range keyTable {
+		if def.shifted == "" {
+			continue
+		}
+		want, wantShift := def.evdev, true
+		if plain, ok := keysByKey[def.shifted]; ok {
+			want, wantShift = plain.evdev, false
+		}
+		evdev, shift, ok := evdevForEvent(&KeyboardEvent{Key: def.shifted, Action: "press"})
+		if !ok || evdev != want || shift != wantShift {
+			t.Errorf("%q: evdevForEvent = %d, %v, %v ; attendu %d, %v", def.shifted, evdev, shift, ok, want, wantShift)
+		}
+	}
+}
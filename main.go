@@ -2,7 +2,10 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -11,12 +14,12 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/kbinani/screenshot"
 )
 
 var upgrader = websocket.Upgrader{
@@ -25,41 +28,75 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-type ControlEvent struct {
-	Type string      `json:"type"`
-	Data interface{} `json:"data"`
+type ScreenStreamer struct {
+	capturer         Capturer
+	geometry         *displayGeometry
+	clients          *clientRegistry
+	captures         *captureCache
+	clipboard        *clipboardWatcher
+	primary          *clipboardWatcher
+	clipboardHistory int
+	clipboardBudget  *clipboardBudget
+	keyframeInterval time.Duration
+	inputRate        int
+	uploads          *uploadManager // nil = envoi de fichiers désactivé
 }
 
-type ClipboardEvent struct {
-	Text   string `json:"text"`
-	Action string `json:"action"` // "get", "set"
+func NewScreenStreamer(capturer Capturer) *ScreenStreamer {
+	s := &ScreenStreamer{
+		capturer:         capturer,
+		geometry:         newDisplayGeometry(capturer),
+		clients:          newClientRegistry(),
+		clipboardHistory: 20,
+		clipboardBudget:  &clipboardBudget{max: clipboardHistoryBudget},
+		keyframeInterval: 10 * time.Second,
+	}
+	s.captures = newCaptureCache(s.captureScreen)
+	s.setClipboardPolicy(&clipboardPolicy{direction: clipboardBidirectional, maxSize: maxClipboardSize})
+	return s
 }
 
-type ScreenStreamer struct {
-	clients       map[*websocket.Conn]bool
-	currentScreen int
-	currentFPS    int
-	fpsChanged    chan int
+// setClipboardPolicy remplace la politique des deux sélections ; à appeler
+// avant le premier client.
+func (s *ScreenStreamer) setClipboardPolicy(policy *clipboardPolicy) {
+	s.clipboard = newClipboardWatcher(selectionClipboard, policy)
+	s.primary = newClipboardWatcher(selectionPrimary, policy)
 }
 
-func NewScreenStreamer() *ScreenStreamer {
-	return &ScreenStreamer{
-		clients:       make(map[*websocket.Conn]bool),
-		currentScreen: -1,
-		currentFPS:    10,
-		fpsChanged:    make(chan int, 1),
-	}
+func (s *ScreenStreamer) addClient(conn *websocket.Conn) *client {
+	c := newClient(conn, s.keyframeInterval)
+	c.limiter = newRateLimiter(s.inputRate)
+	c.clipHistory.limit = s.clipboardHistory
+	c.clipHistory.budget = s.clipboardBudget
+	total := s.clients.add(c)
+	go c.writePump(s.removeClient)
+	log.Printf("Client %d connecté. Total: %d", c.id, total)
+	return c
 }
 
-func (s *ScreenStreamer) addClient(conn *websocket.Conn) {
-	s.clients[conn] = true
-	log.Printf("Client connecté. Total: %d", len(s.clients))
+func (s *ScreenStreamer) removeClient(c *client) {
+	removed, total := s.clients.remove(c)
+	if !removed {
+		return
+	}
+	c.close()
+	s.clipboard.unsubscribe(c)
+	s.primary.unsubscribe(c)
+	c.clipHistory.clear()
+	if s.uploads != nil {
+		s.uploads.detach(c.id)
+	}
+	log.Printf("Client %d déconnecté. Total: %d (frames envoyées: %d, abandonnées: %d)",
+		c.id, total, c.sent.Load(), c.dropped.Load())
 }
 
-func (s *ScreenStreamer) removeClient(conn *websocket.Conn) {
-	delete(s.clients, conn)
-	conn.Close()
-	log.Printf("Client déconnecté. Total: %d", len(s.clients))
+func (s *ScreenStreamer) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats := []clientStats{}
+	for _, c := range s.clients.snapshot() {
+		stats = append(stats, c.stats())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
 }
 
 func simulateMouseClick(x, y int, button string, action string) error {
@@ -112,41 +149,10 @@ func simulateMouseWindows(x, y int, button string, action string) error {
 }
 
 func simulateMouseLinux(x, y int, button string, action string) error {
-	if action == "move" || action == "drag" {
-		cmd := exec.Command("xdotool", "mousemove", strconv.Itoa(x), strconv.Itoa(y))
-		return cmd.Run()
-	} else if action == "down" {
-		buttonNum := "1"
-		if button == "right" {
-			buttonNum = "3"
-		} else if button == "middle" {
-			buttonNum = "2"
-		}
-		cmd := exec.Command("xdotool", "mousemove", strconv.Itoa(x), strconv.Itoa(y), "mousedown", buttonNum)
-		return cmd.Run()
-	} else if action == "up" {
-		buttonNum := "1"
-		if button == "right" {
-			buttonNum = "3"
-		} else if button == "middle" {
-			buttonNum = "2"
-		}
-		cmd := exec.Command("xdotool", "mouseup", buttonNum)
-		return cmd.Run()
-	} else if strings.HasPrefix(action, "scroll:") {
-		// scroll:1 = haut, scroll:-1 = bas
-		parts := strings.Split(action, ":")
-		if len(parts) == 2 {
-			if parts[1] == "1" {
-				cmd := exec.Command("xdotool", "click", "4") // scroll up
-				return cmd.Run()
-			} else if parts[1] == "-1" {
-				cmd := exec.Command("xdotool", "click", "5") // scroll down
-				return cmd.Run()
-			}
-		}
+	if linuxInput == nil {
+		return fmt.Errorf("aucun backend d'entrée Linux disponible")
 	}
-	return nil
+	return linuxInput.Mouse(x, y, button, action)
 }
 
 func simulateMouseMacOS(x, y int, button string, action string) error {
@@ -177,23 +183,38 @@ func simulateMouseMacOS(x, y int, button string, action string) error {
 	return nil
 }
 
-func simulateKeyboard(key string, action string, ctrl, alt, shift bool) error {
+func simulateKeyboard(ev *KeyboardEvent) error {
+	switch runtime.GOOS {
+	case "windows":
+		return simulateKeyboardWindows(ev.Key, ev.Action, ev.Ctrl, ev.Alt, ev.Shift)
+	case "linux":
+		return simulateKeyboardLinux(ev)
+	case "darwin":
+		return simulateKeyboardMacOS(ev.Key, ev.Action, ev.Ctrl, ev.Alt, ev.Shift, ev.Meta)
+	default:
+		return fmt.Errorf("OS non supporté: %s", runtime.GOOS)
+	}
+}
+
+func simulateText(text string) error {
 	switch runtime.GOOS {
 	case "windows":
-		return simulateKeyboardWindows(key, action, ctrl, alt, shift)
+		return simulateTextWindows(text)
 	case "linux":
-		return simulateKeyboardLinux(key, action, ctrl, alt, shift)
+		return simulateTextLinux(text)
 	case "darwin":
-		return simulateKeyboardMacOS(key, action, ctrl, alt, shift)
+		return simulateTextMacOS(text)
 	default:
 		return fmt.Errorf("OS non supporté: %s", runtime.GOOS)
 	}
 }
 
-func getClipboard() (string, error) {
+// getClipboard lit le texte d'une sélection X11 ("clipboard", "primary").
+// Windows et macOS n'ont qu'un presse-papiers : selection y est ignorée.
+func getClipboard(selection string) (string, error) {
 	switch runtime.GOOS {
 	case "linux":
-		cmd := exec.Command("xclip", "-o", "-selection", "clipboard")
+		cmd := exec.Command("xclip", "-o", "-selection", selection)
 		output, err := cmd.Output()
 		return string(output), err
 	case "windows":
@@ -209,10 +230,10 @@ func getClipboard() (string, error) {
 	}
 }
 
-func setClipboard(text string) error {
+func setClipboard(selection, text string) error {
 	switch runtime.GOOS {
 	case "linux":
-		cmd := exec.Command("xclip", "-i", "-selection", "clipboard")
+		cmd := exec.Command("xclip", "-i", "-selection", selection)
 		cmd.Stdin = strings.NewReader(text)
 		return cmd.Run()
 	case "windows":
@@ -241,6 +262,20 @@ func simulateKeyboardWindows(key string, action string, ctrl, alt, shift bool) e
 		winKey = "{TAB}"
 	case "Escape":
 		winKey = "{ESC}"
+	case "Delete":
+		winKey = "{DEL}"
+	case "Insert":
+		winKey = "{INS}"
+	case "Home", "End":
+		winKey = "{" + strings.ToUpper(key) + "}"
+	case "PageUp":
+		winKey = "{PGUP}"
+	case "PageDown":
+		winKey = "{PGDN}"
+	case "ArrowUp", "ArrowDown", "ArrowLeft", "ArrowRight":
+		winKey = "{" + strings.ToUpper(strings.TrimPrefix(key, "Arrow")) + "}"
+	case "F1", "F2", "F3", "F4", "F5", "F6", "F7", "F8", "F9", "F10", "F11", "F12":
+		winKey = "{" + key + "}"
 	}
 
 	modifiers := ""
@@ -263,46 +298,53 @@ func simulateKeyboardWindows(key string, action string, ctrl, alt, shift bool) e
 	return cmd.Run()
 }
 
-func simulateKeyboardLinux(key string, action string, ctrl, alt, shift bool) error {
-	args := []string{}
-
-	if action == "down" {
-		args = append(args, "keydown")
-	} else if action == "up" {
-		args = append(args, "keyup")
-	} else {
-		args = append(args, "key")
+func simulateKeyboardLinux(ev *KeyboardEvent) error {
+	if linuxInput == nil {
+		return fmt.Errorf("aucun backend d'entrée Linux disponible")
 	}
+	return linuxInput.Keyboard(ev)
+}
 
-	keyCombo := ""
-	if ctrl {
-		keyCombo += "ctrl+"
-	}
-	if alt {
-		keyCombo += "alt+"
-	}
-	if shift {
-		keyCombo += "shift+"
+func simulateTextLinux(text string) error {
+	backend, ok := linuxInput.(textInput)
+	if !ok {
+		return fmt.Errorf("saisie de texte non supportée par le backend d'entrée")
 	}
+	return backend.Text(text)
+}
 
-	xKey := strings.ToLower(key)
-	switch key {
-	case " ":
-		xKey = "space"
-	case "Enter":
-		xKey = "Return"
-	case "Backspace":
-		xKey = "BackSpace"
+func simulateTextWindows(text string) error {
+	// SendKeys : les caractères spéciaux sont échappés entre accolades
+	var keys strings.Builder
+	for _, r := range text {
+		switch r {
+		case '+', '^', '%', '~', '(', ')', '{', '}', '[', ']':
+			keys.WriteString("{" + string(r) + "}")
+		case '\n':
+			keys.WriteString("{ENTER}")
+		case '\r':
+		default:
+			keys.WriteRune(r)
+		}
 	}
 
-	keyCombo += xKey
-	args = append(args, keyCombo)
+	psScript := fmt.Sprintf(`
+	Add-Type -AssemblyName System.Windows.Forms
+	[System.Windows.Forms.SendKeys]::SendWait('%s')
+	`, strings.ReplaceAll(keys.String(), "'", "''"))
+
+	cmd := exec.Command("powershell", "-WindowStyle", "Hidden", "-Command", psScript)
+	return cmd.Run()
+}
 
-	cmd := exec.Command("xdotool", args...)
+func simulateTextMacOS(text string) error {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text)
+	script := fmt.Sprintf(`tell application "System Events" to keystroke "%s"`, escaped)
+	cmd := exec.Command("osascript", "-e", script)
 	return cmd.Run()
 }
 
-func simulateKeyboardMacOS(key string, action string, ctrl, alt, shift bool) error {
+func simulateKeyboardMacOS(key string, action string, ctrl, alt, shift, meta bool) error {
 	modifiers := ""
 	if ctrl {
 		modifiers += "control down, "
@@ -313,6 +355,9 @@ func simulateKeyboardMacOS(key string, action string, ctrl, alt, shift bool) err
 	if shift {
 		modifiers += "shift down, "
 	}
+	if meta {
+		modifiers += "command down, "
+	}
 
 	macKey := key
 	switch key {
@@ -329,88 +374,83 @@ func simulateKeyboardMacOS(key string, action string, ctrl, alt, shift bool) err
 	return cmd.Run()
 }
 
-func captureScreen(screenIndex int) (image.Image, error) {
-	var img *image.RGBA
-	var err error
-
-	if screenIndex == -1 {
-		if screenshot.NumActiveDisplays() == 0 {
-			return nil, fmt.Errorf("aucun écran détecté")
-		}
-
-		bounds := screenshot.GetDisplayBounds(0)
-		for i := 1; i < screenshot.NumActiveDisplays(); i++ {
-			bounds = bounds.Union(screenshot.GetDisplayBounds(i))
-		}
-		img, err = screenshot.CaptureRect(bounds)
-	} else {
-		if screenIndex >= screenshot.NumActiveDisplays() {
-			return nil, fmt.Errorf("écran %d non trouvé (max: %d)", screenIndex, screenshot.NumActiveDisplays()-1)
-		}
-		bounds := screenshot.GetDisplayBounds(screenIndex)
-		img, err = screenshot.CaptureRect(bounds)
+func (s *ScreenStreamer) captureScreen(screenIndex int) (*image.RGBA, error) {
+	view, err := s.geometry.view(screenIndex)
+	if err != nil {
+		return nil, err
 	}
 
+	img, err := s.capturer.CaptureRect(view)
 	if err != nil {
 		return nil, fmt.Errorf("erreur capture: %v", err)
 	}
-	return img, nil
+	return scaleFrame(img, s.geometry.frameSize(view)), nil
 }
 
-func (s *ScreenStreamer) broadcastImage(img image.Image, quality int) error {
-	var buf bytes.Buffer
-
-	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
-	if err != nil {
-		return fmt.Errorf("erreur encodage: %v", err)
-	}
-
-	data := buf.Bytes()
-	for client := range s.clients {
-		err := client.WriteMessage(websocket.BinaryMessage, data)
+func (s *ScreenStreamer) sendFrame(c *client, img *image.RGBA, quality int) error {
+	var data []byte
+	delta := c.delta.enabled.Load()
+	if delta {
+		frame, err := c.delta.encode(img, quality)
 		if err != nil {
-			log.Printf("Erreur envoi client: %v", err)
-			s.removeClient(client)
+			return err
+		}
+		if frame == nil {
+			return nil
 		}
+		data = frame
+	} else {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return fmt.Errorf("erreur encodage: %v", err)
+		}
+		data = buf.Bytes()
 	}
+
+	c.queueFrame(outFrame{data: data, delta: delta})
 	return nil
 }
 
-func adjustMouseCoordinates(screenIndex int, x, y int) (int, int) {
-	if screenIndex == -1 {
-		return x, y
+// sendFullFrame envoie immédiatement à un seul client une frame complète en
+// haute qualité, hors du rythme du ticker.
+func (s *ScreenStreamer) sendFullFrame(c *client) error {
+	if !s.clients.contains(c) {
+		return nil
 	}
 
-	if screenIndex >= screenshot.NumActiveDisplays() {
-		return x, y
+	img, err := s.captures.get(int(c.screen.Load()), 0)
+	if err != nil {
+		return err
 	}
 
-	bounds := screenshot.GetDisplayBounds(screenIndex)
-	adjustedX := bounds.Min.X + x
-	adjustedY := bounds.Min.Y + y
-
-	log.Printf("Coord adjustment: screen %d, original (%d,%d) -> adjusted (%d,%d)",
-		screenIndex, x, y, adjustedX, adjustedY)
+	const refreshQuality = 90
+	var data []byte
+	delta := c.delta.enabled.Load()
+	if delta {
+		c.delta.requestKeyframe()
+		data, err = c.delta.encode(img, refreshQuality)
+	} else {
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: refreshQuality})
+		data = buf.Bytes()
+	}
+	if err != nil {
+		return fmt.Errorf("erreur encodage: %v", err)
+	}
 
-	return adjustedX, adjustedY
+	c.queueFrame(outFrame{data: data, delta: delta})
+	return nil
 }
 
-func (s *ScreenStreamer) startClipboardSync(conn *websocket.Conn) {
-	var last string
-	for {
-		text, err := getClipboard()
-		if err == nil && text != last {
-			last = text
-			response := ControlEvent{
-				Type: "clipboard",
-				Data: ClipboardEvent{Text: text, Action: "content"},
-			}
-			if data, err := json.Marshal(response); err == nil {
-				conn.WriteMessage(websocket.TextMessage, data)
-			}
-		}
-		time.Sleep(2 * time.Second)
+// adjustMouseCoordinates convertit un point de la frame affichée par le
+// navigateur en coordonnées d'injection (voir displayGeometry).
+func (s *ScreenStreamer) adjustMouseCoordinates(screenIndex int, x, y int) (int, int) {
+	adjustedX, adjustedY := s.geometry.toInput(screenIndex, x, y)
+	if adjustedX != x || adjustedY != y {
+		log.Printf("Coord adjustment: screen %d, original (%d,%d) -> adjusted (%d,%d)",
+			screenIndex, x, y, adjustedX, adjustedY)
 	}
+	return adjustedX, adjustedY
 }
 
 func (s *ScreenStreamer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -420,159 +460,348 @@ func (s *ScreenStreamer) handleWebSocket(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	s.addClient(conn)
-	go s.startClipboardSync(conn)
+	conn.SetReadLimit(s.readLimit())
+	c := s.addClient(conn)
+	s.sendHello(c)
+	s.clipboard.subscribe(c)
+	go s.startStreaming(c)
+	go s.inputPump(c)
 	go func() {
-		defer s.removeClient(conn)
+		defer s.removeClient(c)
 		for {
 			_, message, err := conn.ReadMessage()
 			if err != nil {
+				if errors.Is(err, websocket.ErrReadLimit) {
+					log.Printf("Client %d: message de plus de %d octets, connexion fermée", c.id, s.readLimit())
+				}
 				break
 			}
 
 			command := strings.TrimSpace(string(message))
 
+			var event ControlEvent
 			if strings.HasPrefix(command, "{") {
-				var controlEvent ControlEvent
-				if err := json.Unmarshal(message, &controlEvent); err == nil {
-					switch controlEvent.Type {
-					case "mouse":
-						if mouseData, ok := controlEvent.Data.(map[string]interface{}); ok {
-							x := int(mouseData["x"].(float64))
-							y := int(mouseData["y"].(float64))
-							button := mouseData["button"].(string)
-							action := mouseData["action"].(string)
-
-							adjustedX, adjustedY := adjustMouseCoordinates(s.currentScreen, x, y)
-
-							// Gestion spéciale du scroll
-							if action == "scroll" {
-								if scroll, ok := mouseData["scroll"].(float64); ok {
-									err := simulateMouseClick(adjustedX, adjustedY, "wheel", fmt.Sprintf("scroll:%d", int(scroll)))
-									if err != nil {
-										log.Printf("Erreur scroll souris: %v", err)
-									}
-								}
-								continue
-							}
-
-							err := simulateMouseClick(adjustedX, adjustedY, button, action)
-							if err != nil {
-								log.Printf("Erreur souris: %v", err)
-							}
-						}
-					case "keyboard":
-						if keyData, ok := controlEvent.Data.(map[string]interface{}); ok {
-							key := keyData["key"].(string)
-							action := keyData["action"].(string)
-							ctrl := keyData["ctrl"].(bool)
-							alt := keyData["alt"].(bool)
-							shift := keyData["shift"].(bool)
-
-							err := simulateKeyboard(key, action, ctrl, alt, shift)
-							if err != nil {
-								log.Printf("Erreur clavier: %v", err)
-							}
-						}
-					case "clipboard":
-						if clipData, ok := controlEvent.Data.(map[string]interface{}); ok {
-							action := clipData["action"].(string)
-							if action == "get" {
-								text, err := getClipboard()
-								if err != nil {
-									log.Printf("Erreur lecture clipboard: %v", err)
-								} else {
-									response := ControlEvent{
-										Type: "clipboard",
-										Data: ClipboardEvent{Text: text, Action: "content"},
-									}
-									if data, err := json.Marshal(response); err == nil {
-										conn.WriteMessage(websocket.TextMessage, data)
-									}
-								}
-							} else if action == "set" {
-								text := clipData["text"].(string)
-								err := setClipboard(text)
-								if err != nil {
-									log.Printf("Erreur écriture clipboard: %v", err)
-								}
-							}
-						}
-					}
+				if err := json.Unmarshal(message, &event); err != nil {
+					s.rejectEvent(c, "", &protocolError{Code: errInvalidJSON, Message: fmt.Sprintf("JSON invalide: %v", err)})
+					continue
 				}
-				continue
-			}
-
-			switch {
-			case command == "refresh":
-				continue
-			case strings.HasPrefix(command, "screen:"):
-				screenStr := strings.TrimPrefix(command, "screen:")
-				if screenStr == "all" {
-					s.currentScreen = -1
-				} else {
-					var screenIndex int
-					if n, _ := fmt.Sscanf(screenStr, "%d", &screenIndex); n == 1 {
-						if screenIndex < screenshot.NumActiveDisplays() {
-							s.currentScreen = screenIndex
-						}
-					}
+			} else {
+				legacy, ok := parseLegacyCommand(command)
+				if !ok {
+					s.rejectEvent(c, "", &protocolError{Code: errUnknownCommand, Message: fmt.Sprintf("commande inconnue: %q", command)})
+					continue
 				}
-			case strings.HasPrefix(command, "fps:"):
-				fpsStr := strings.TrimPrefix(command, "fps:")
-				var fps int
-				if n, _ := fmt.Sscanf(fpsStr, "%d", &fps); n == 1 && fps > 0 && fps <= 120 {
-					s.currentFPS = fps
-					select {
-					case s.fpsChanged <- fps:
-					default:
-					}
-					log.Printf("FPS changé vers: %d", fps)
+				if !c.legacyWarned {
+					log.Printf("Client %d: commandes texte obsolètes (protocole v%d), l'interface doit passer au protocole v%d",
+						c.id, legacyProtocolVersion, protocolVersion)
+					c.legacyWarned = true
 				}
+				event = legacy
 			}
+
+			s.handleControlEvent(c, event)
 		}
 	}()
 }
 
-func (s *ScreenStreamer) startStreaming() {
-	currentFPS := s.currentFPS
+// readLimit borne la taille d'un message entrant avant qu'il soit lu en
+// mémoire : le plus gros message légitime est un presse-papiers de
+// -clipboard-max ou un morceau d'envoi, en base64, accompagné pour le HTML de
+// son équivalent texte, plus l'enveloppe JSON.
+func (s *ScreenStreamer) readLimit() int64 {
+	payload := max(s.clipboard.policy.maxSize, uploadChunkSize)
+	return int64(2*base64.StdEncoding.EncodedLen(payload) + 64<<10)
+}
+
+// releaseInputs relâche ce que le client a laissé enfoncé (glisser en cours,
+// Ctrl envoyé en keydown...) pour que la VM ne reste pas dans cet état.
+func (s *ScreenStreamer) releaseInputs(c *client, reason string) {
+	releaseTouch(c)
+	keys, buttons := c.pressed.releaseAll()
+	if len(keys) == 0 && len(buttons) == 0 {
+		return
+	}
+	log.Printf("Client %d: relâchement de %d touche(s) et %d bouton(s) (%s)", c.id, len(keys), len(buttons), reason)
+
+	for i := range keys {
+		if err := simulateKeyboard(&keys[i]); err != nil {
+			log.Printf("Erreur relâchement touche %s: %v", keyID(&keys[i]), err)
+		}
+	}
+	for _, b := range buttons {
+		var err error
+		if b.relative {
+			err = simulateRelativeMouse(0, 0, b.button, "up")
+		} else {
+			err = simulateMouseClick(b.x, b.y, b.button, "up")
+		}
+		if err != nil {
+			log.Printf("Erreur relâchement bouton %s: %v", b.button, err)
+		}
+	}
+}
+
+func (s *ScreenStreamer) sendHello(c *client) error {
+	var screens []ScreenInfo
+	for i := 0; i < s.capturer.NumDisplays(); i++ {
+		bounds := s.capturer.DisplayBounds(i)
+		screens = append(screens, ScreenInfo{
+			Index:  i,
+			X:      bounds.Min.X,
+			Y:      bounds.Min.Y,
+			Width:  bounds.Dx(),
+			Height: bounds.Dy(),
+		})
+	}
+
+	return c.sendEvent("hello", HelloReply{
+		Version:   protocolVersion,
+		Server:    serverName,
+		Session:   c.id,
+		Screens:   screens,
+		Codecs:    supportedCodecs,
+		Input:     inputAvailable(),
+		Clipboard: clipboardAvailable() && s.clipboard.policy.direction != clipboardOff,
+
+		ClipboardPolicy: s.clipboard.policy.direction,
+		Primary:         runtime.GOOS == "linux" && clipboardAvailable(),
+		Text:            textAvailable(),
+		Upload:          s.uploads != nil,
+		UploadMax:       s.uploadMax(),
+	})
+}
+
+// handleControlEvent traite un message entrant. Un message refusé est
+// signalé à l'expéditeur par un événement "error" sans couper la connexion.
+func (s *ScreenStreamer) handleControlEvent(c *client, event ControlEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Client %d: panique sur l'événement %q: %v\n%s", c.id, event.Type, r, debug.Stack())
+			c.sendEvent("error", ErrorEvent{Code: errInternal, Event: event.Type, Message: "erreur interne"})
+		}
+	}()
+
+	if err := s.dispatchControlEvent(c, event); err != nil {
+		s.rejectEvent(c, event.Type, err)
+	}
+}
+
+func (s *ScreenStreamer) rejectEvent(c *client, eventType string, err error) {
+	code := errInvalidEvent
+	var perr *protocolError
+	if errors.As(err, &perr) {
+		code = perr.Code
+	}
+	log.Printf("Client %d: événement %q refusé (%s): %v", c.id, eventType, code, err)
+	c.sendEvent("error", ErrorEvent{Code: code, Event: eventType, Message: err.Error()})
+}
+
+func (s *ScreenStreamer) dispatchControlEvent(c *client, event ControlEvent) error {
+	decoded, err := decodeControlEvent(event)
+	if err != nil {
+		return err
+	}
+
+	switch ev := decoded.(type) {
+	case *HelloEvent:
+		version := min(ev.Version, protocolVersion)
+		c.protocol.Store(int32(version))
+		log.Printf("Client %d: protocole v%d", c.id, version)
+
+	case *RefreshEvent:
+		c.requestRefresh()
+
+	case *KeyframeEvent:
+		c.delta.requestKeyframe()
+
+	case *CodecEvent:
+		switch ev.Codec {
+		case "tiles":
+			c.delta.requestKeyframe()
+			c.delta.enabled.Store(true)
+		case "jpeg":
+			c.delta.enabled.Store(false)
+		}
+
+	case *ScreenEvent:
+		if ev.Screen >= s.capturer.NumDisplays() {
+			return invalidEvent("écran %d non trouvé (max: %d)", ev.Screen, s.capturer.NumDisplays()-1)
+		}
+		c.screen.Store(int32(ev.Screen))
+
+	case *FPSEvent:
+		c.setFPS(ev.FPS)
+		log.Printf("Client %d: FPS changé vers: %d", c.id, ev.FPS)
+
+	case *QualityEvent:
+		c.quality.Store(int32(ev.Quality))
+
+	case *MouseEvent, *ScrollEvent, *RelativeMouseEvent, *TouchEvent, *KeyboardEvent, *TextEvent, *ControlStateEvent, *ReleaseEvent:
+		if state, ok := ev.(*ControlStateEvent); ok {
+			c.controlEnabled.Store(state.Enabled)
+		}
+		return s.queueInput(c, event.Type, decoded)
+
+	case *TypeTextEvent:
+		if ev.Action == "cancel" {
+			c.cancelTyping()
+			return nil
+		}
+		// Le texte vient du presse-papiers du navigateur : même politique
+		// qu'un "set"
+		content, err := s.clipboard.policy.filter(c, clipboardBrowserToVM, clipboardContent{mime: mimeText, data: []byte(ev.Text), text: ev.Text})
+		if err != nil {
+			return err
+		}
+		delay := defaultTypeDelay
+		if ev.Delay > 0 {
+			delay = time.Duration(ev.Delay) * time.Millisecond
+		}
+		c.startTyping(content.text, delay)
+
+	case *ClipboardEvent:
+		return s.handleClipboardEvent(c, ev)
+
+	case *UploadEvent:
+		return s.handleUploadEvent(c, ev)
+	}
+	return nil
+}
+
+// injectInput exécute un événement d'entrée sorti de la file du client.
+func (s *ScreenStreamer) injectInput(c *client, in queuedInput) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Client %d: panique sur l'événement %q: %v\n%s", c.id, in.eventType, r, debug.Stack())
+			c.sendEvent("error", ErrorEvent{Code: errInternal, Event: in.eventType, Message: "erreur interne"})
+			// La saisie typeText attend le résultat de chaque caractère
+			if typed, ok := in.event.(*typedRune); ok {
+				typed.result <- fmt.Errorf("erreur interne")
+			}
+		}
+	}()
+
+	switch ev := in.event.(type) {
+	case *MouseEvent:
+		adjustedX, adjustedY := s.adjustMouseCoordinates(int(c.screen.Load()), ev.X, ev.Y)
+
+		// Gestion spéciale du scroll
+		if ev.Action == "scroll" {
+			err := simulateMouseClick(adjustedX, adjustedY, "wheel", fmt.Sprintf("scroll:%d", ev.Scroll))
+			if err != nil {
+				log.Printf("Erreur scroll souris: %v", err)
+			}
+			c.reportResult(serviceInput, "mouse", err)
+			return
+		}
+
+		err := simulateMouseClick(adjustedX, adjustedY, ev.Button, ev.Action)
+		if err == nil || ev.Action == "up" {
+			c.pressed.trackButton(adjustedX, adjustedY, ev.Button, ev.Action)
+		}
+		if err != nil {
+			log.Printf("Erreur souris: %v", err)
+		}
+		c.reportResult(serviceInput, "mouse", err)
+
+	case *ScrollEvent:
+		adjustedX, adjustedY := s.adjustMouseCoordinates(int(c.screen.Load()), ev.X, ev.Y)
+		dx, dy := ev.units()
+		err := simulateScroll(adjustedX, adjustedY, dx, dy, &c.scroll)
+		if err != nil {
+			log.Printf("Erreur défilement: %v", err)
+		}
+		c.reportResult(serviceInput, "scroll", err)
+
+	case *RelativeMouseEvent:
+		err := simulateRelativeMouse(ev.DX, ev.DY, ev.Button, ev.Action)
+		if err == nil || ev.Action == "up" {
+			// Position inconnue : relâché en relatif à la déconnexion
+			c.pressed.trackRelativeButton(ev.Button, ev.Action)
+		}
+		if err != nil {
+			log.Printf("Erreur souris relative: %v", err)
+		}
+		c.reportResult(serviceInput, "mouseRelative", err)
+
+	case *TouchEvent:
+		err := s.handleTouch(c, ev)
+		if err != nil {
+			log.Printf("Erreur tactile: %v", err)
+		}
+		c.reportResult(serviceInput, "touch", err)
+
+	case *KeyboardEvent:
+		err := simulateKeyboard(ev)
+		if err == nil || ev.Action == "up" {
+			c.pressed.trackKey(ev)
+		}
+		if err != nil {
+			log.Printf("Erreur clavier: %v", err)
+		}
+		c.reportResult(serviceInput, "keyboard", err)
+
+	case *TextEvent:
+		err := simulateText(ev.Text)
+		if err != nil {
+			log.Printf("Erreur saisie texte: %v", err)
+		}
+		c.reportResult(serviceInput, "text", err)
+
+	case *touchLongPress:
+		c.touch.longPress(c, ev.press)
+
+	case *typedRune:
+		ev.result <- typeRune(ev.r, ev.useText)
+
+	case *ControlStateEvent:
+		if !ev.Enabled {
+			log.Printf("Client %d: contrôle désactivé", c.id)
+			c.cancelTyping()
+			s.releaseInputs(c, "contrôle retiré")
+		}
+
+	case *ReleaseEvent:
+		s.releaseInputs(c, "fenêtre inactive")
+	}
+}
+
+func (s *ScreenStreamer) startStreaming(c *client) {
+	currentFPS := int(c.fps.Load())
 	ticker := time.NewTicker(time.Second / time.Duration(currentFPS))
 	defer ticker.Stop()
 
-	log.Printf("Streaming démarré à %d FPS", currentFPS)
+	log.Printf("Client %d: streaming démarré à %d FPS", c.id, currentFPS)
 
 	frameCount := 0
 	lastStatsTime := time.Now()
+	var last *image.RGBA
 
 	for {
 		select {
-		case <-ticker.C:
-			if len(s.clients) == 0 {
-				continue
-			}
+		case <-c.done:
+			return
 
+		case <-ticker.C:
 			frameStart := time.Now()
 
-			img, err := captureScreen(s.currentScreen)
+			// Une capture de moins d'une demi-période, faite pour un autre
+			// client sur le même écran, est réutilisée telle quelle.
+			maxAge := time.Second / time.Duration(currentFPS) / 2
+			img, err := s.captures.get(int(c.screen.Load()), maxAge)
 			if err != nil {
 				log.Printf("Erreur capture: %v", err)
 				continue
 			}
 			captureTime := time.Since(frameStart)
 
-			quality := 70
-			if currentFPS >= 90 {
-				quality = 30
-			} else if currentFPS >= 60 {
-				quality = 35
-			} else if currentFPS >= 30 {
-				quality = 50
-			} else if currentFPS <= 5 {
-				quality = 90
+			if img == last {
+				continue
 			}
+			last = img
 
 			encodeStart := time.Now()
-			err = s.broadcastImage(img, quality)
+			err = s.sendFrame(c, img, c.jpegQuality(currentFPS))
 			if err != nil {
 				log.Printf("Erreur diffusion: %v", err)
 				continue
@@ -584,19 +813,24 @@ func (s *ScreenStreamer) startStreaming() {
 
 			if time.Since(lastStatsTime) > 5*time.Second {
 				actualFPS := float64(frameCount) / time.Since(lastStatsTime).Seconds()
-				log.Printf("FPS cible: %d | FPS réel: %.1f | Capture: %dms | Encode+Send: %dms | Total: %dms",
-					currentFPS, actualFPS, captureTime.Milliseconds(), encodeTime.Milliseconds(), frameTotal.Milliseconds())
+				log.Printf("Client %d: FPS cible: %d | FPS réel: %.1f | Capture: %dms | Encode+Send: %dms | Total: %dms | Frames abandonnées: %d",
+					c.id, currentFPS, actualFPS, captureTime.Milliseconds(), encodeTime.Milliseconds(), frameTotal.Milliseconds(), c.dropped.Load())
 				frameCount = 0
 				lastStatsTime = time.Now()
 			}
 
-		case newFPS := <-s.fpsChanged:
+		case <-c.refresh:
+			if err := s.sendFullFrame(c); err != nil {
+				log.Printf("Erreur refresh: %v", err)
+			}
+
+		case newFPS := <-c.fpsChanged:
 			if newFPS != currentFPS {
 				oldFPS := currentFPS
 				currentFPS = newFPS
 				ticker.Stop()
 				ticker = time.NewTicker(time.Second / time.Duration(currentFPS))
-				log.Printf("FPS changé: %d -> %d", oldFPS, currentFPS)
+				log.Printf("Client %d: FPS changé: %d -> %d", c.id, oldFPS, currentFPS)
 				frameCount = 0
 				lastStatsTime = time.Now()
 			}
@@ -630,8 +864,10 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
         #screen { max-width: 100%; max-height: 100%; width: auto; height: auto; border: 2px solid #333; border-radius: 8px; cursor: pointer; transition: transform 0.1s; object-fit: contain; }
         #screen:hover { transform: scale(1.01); border-color: #4CAF50; }
         #screen.fullscreen { position: fixed; top: 0; left: 0; width: 100vw !important; height: 100vh !important; max-width: 100vw; max-height: 100vh; z-index: 1000; border: none; border-radius: 0; background: black; }
+        #degraded-banner { display: none; margin: 5px auto; padding: 6px 12px; border-radius: 4px; background: #FF9800; color: black; font-size: 13px; font-weight: bold; }
         #info { font-size: 12px; color: #aaa; margin: 5px 0; }
-        .screen-selector, .fps-selector { display: flex; gap: 5px; align-items: center; }
+        select { background: #333; color: white; border: none; padding: 8px; border-radius: 6px; font-size: 14px; max-width: 280px; }
+        .screen-selector, .fps-selector, .quality-selector { display: flex; gap: 5px; align-items: center; }
         @media (max-width: 768px) { body { padding: 5px; } h1 { font-size: 1.2em; margin: 5px 0; } button { padding: 6px 12px; font-size: 12px; } #controls { gap: 5px; } }
     </style>
 </head>
@@ -639,18 +875,26 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
     <div id="container">
         <h1>VM Desktop Viewer with Remote Control</h1>
         <div id="status" class="disconnected">Disconnected</div>
+        <div id="degraded-banner"></div>
         <div id="controls">
             <button id="connectBtn" onclick="connect()">Connect</button>
             <button id="disconnectBtn" onclick="disconnect()">Disconnect</button>
             <button onclick="toggleFullscreen()">Fullscreen</button>
             <button id="controlBtn" onclick="toggleControl()" class="control-btn">Enable Control</button>
             <button onclick="syncClipboard()">Sync Clipboard</button>
+            <button id="lockBtn" onclick="togglePointerLock()" title="Relative mouse mode (Esc to leave)">Pointer Lock</button>
+            <button id="typeBtn" onclick="typeClipboard()" title="Types the browser clipboard as keystrokes">Type Clipboard</button>
+            <button id="primaryBtn" onclick="togglePrimary()" title="Sync the X11 PRIMARY selection (select to copy, middle-click to paste)" style="display:none">PRIMARY</button>
+            <button onclick="requestClipboardHistory()" title="Paste one of the last copies into the VM">History</button>
+            <select id="history-select" onchange="applyClipboardHistory(this)" style="display:none"></select>
             <div class="screen-selector">
                 <label>Screen:</label>
-                <button onclick="changeScreen('all')" class="screen-btn active" data-screen="all">All</button>
-                <button onclick="changeScreen(0)" class="screen-btn" data-screen="0">1</button>
-                <button onclick="changeScreen(1)" class="screen-btn" data-screen="1">2</button>
-                <button onclick="changeScreen(2)" class="screen-btn" data-screen="2">3</button>
+                <span id="screen-buttons">
+                    <button onclick="changeScreen('all')" class="screen-btn active" data-screen="all">All</button>
+                    <button onclick="changeScreen(0)" class="screen-btn" data-screen="0">1</button>
+                    <button onclick="changeScreen(1)" class="screen-btn" data-screen="1">2</button>
+                    <button onclick="changeScreen(2)" class="screen-btn" data-screen="2">3</button>
+                </span>
             </div>
             <div class="fps-selector">
                 <label>FPS:</label>
@@ -661,26 +905,42 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                 <button onclick="setFPS(60)" class="fps-btn" data-fps="60">60</button>
                 <button onclick="setFPS(120)" class="fps-btn" data-fps="120">120</button>
             </div>
+            <div class="quality-selector">
+                <label>Quality:</label>
+                <button onclick="setQuality('auto')" class="quality-btn active" data-quality="auto">Auto</button>
+                <button onclick="setQuality(30)" class="quality-btn" data-quality="30">30</button>
+                <button onclick="setQuality(50)" class="quality-btn" data-quality="50">50</button>
+                <button onclick="setQuality(70)" class="quality-btn" data-quality="70">70</button>
+                <button onclick="setQuality(90)" class="quality-btn" data-quality="90">90</button>
+            </div>
         </div>
         <div id="info">
             <span id="resolution">Resolution: --</span> | 
             <span id="fps-info">FPS: 10</span> | 
             <span id="current-screen">Current: All Screens</span> |
             <span id="control-status">Control: Disabled</span>
+            <span id="upload-info" style="display:none"> | <span id="upload-status"></span>
+                <button id="uploadCancelBtn" onclick="cancelUploads()" style="display:none">Cancel</button></span>
         </div>
         <div id="screen-container">
-            <canvas id="screen" style="border:2px solid #333; border-radius:8px; cursor:pointer;"></canvas>
+            <canvas id="screen" style="border:2px solid #333; border-radius:8px; cursor:pointer; touch-action:none;"></canvas>
+            <textarea id="ime-input" autocomplete="off" autocapitalize="off" spellcheck="false" style="position:fixed; left:0; top:0; width:1px; height:1px; opacity:0; border:0; padding:0;"></textarea>
         </div>
         <div id="control-indicator" class="control-indicator">REMOTE CONTROL ACTIVE</div>
     </div>
     <script>
 		let manualDisconnect = false;
-        let ws = null, currentScreen = 'all', currentFPS = 10, isFullscreen = false, controlEnabled = false;
+        let ws = null, currentScreen = 'all', currentFPS = 10, currentQuality = 'auto', isFullscreen = false, controlEnabled = false;
         let frameCount = 0, lastFrameTime = 0, fpsDisplay = 0;
         let isMouseDown = false, dragButton = null;
+        let renderQueue = Promise.resolve();
+        const PROTOCOL_VERSION = 2;
+        let serverInfo = null;
         const screen = document.getElementById('screen'), status = document.getElementById('status');
         const connectBtn = document.getElementById('connectBtn'), disconnectBtn = document.getElementById('disconnectBtn');
         const controlBtn = document.getElementById('controlBtn'), controlIndicator = document.getElementById('control-indicator');
+        const imeInput = document.getElementById('ime-input');
+        const textKeys = new Set();
 
         function updateStatus(connected) {
             if (connected) { status.textContent = 'Connected'; status.className = 'connected'; connectBtn.disabled = true; disconnectBtn.disabled = false; }
@@ -696,7 +956,14 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             
             ws.onopen = function() {
                 updateStatus(true);
-                if (ws.readyState === WebSocket.OPEN) { ws.send('screen:' + currentScreen); ws.send('fps:' + currentFPS); }
+                sendMessage('hello', { version: PROTOCOL_VERSION, codecs: ['tiles', 'jpeg'] });
+                sendMessage('codec', { codec: 'tiles' });
+                sendMessage('screen', { screen: currentScreen === 'all' ? -1 : currentScreen });
+                sendMessage('fps', { fps: currentFPS });
+                sendMessage('quality', { quality: currentQuality === 'auto' ? 0 : currentQuality });
+                if (primaryEnabled) sendMessage('clipboard', { action: 'watch', selection: 'primary' });
+                if (controlEnabled) sendMessage('control', { enabled: true });
+                resumeUploads();
                 frameCount = 0; lastFrameTime = Date.now();
             };
             
@@ -704,22 +971,42 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                 if (typeof event.data === 'string') {
                     try {
                         const message = JSON.parse(event.data);
-                        if (message.type === 'clipboard' && message.data.action === 'content') {
-                            navigator.clipboard.writeText(message.data.text).catch(err => console.warn('Cannot write to clipboard:', err));
+                        if (message.type === 'hello') {
+                            handleHello(message.data);
+                        } else if (message.type === 'status') {
+                            setDegraded(message.data.service, message.data.degraded, message.data.message);
+                        } else if (message.type === 'upload') {
+                            handleUploadStatus(message.data);
+                        } else if (message.type === 'typeText') {
+                            handleTypeStatus(message.data);
+                        } else if (message.type === 'ack') {
+                            console.log('Server acknowledged ' + message.data.event + ' ' + (message.data.action || ''));
+                        } else if (message.type === 'error') {
+                            console.warn('Server rejected ' + (message.data.event || 'message') + ': ' + message.data.message);
+                            if (message.data.event === 'typeText') handleTypeStatus({ state: 'failed', typed: 0, total: 0, message: message.data.message });
+                            if (message.data.code === 'input_failed') setDegraded('input', true, message.data.message);
+                            else if (message.data.code === 'clipboard_failed') setDegraded('clipboard', true, message.data.message);
+                            else if (message.data.code === 'upload_failed') { clearUploads(); showUploadStatus(message.data.message); }
+                        } else if (message.type === 'clipboardHistory') {
+                            showClipboardHistory(message.data.entries || []);
+                        } else if (message.type === 'clipboard' && message.data.action === 'content') {
+                            // PRIMARY ne va que dans l'historique : surligner dans la VM
+                            // ne doit pas écraser le presse-papiers du navigateur
+                            if (message.data.selection === 'primary') {
+                                if (historySelect.style.display !== 'none') requestClipboardHistory();
+                            } else {
+                                writeBrowserClipboard(message.data).catch(err => console.warn('Cannot write to clipboard:', err));
+                            }
                         }
                     } catch (e) {}
                     return;
                 }
                 
-                const blob = new Blob([event.data], { type: 'image/jpeg' });
-				createImageBitmap(blob).then(bitmap => {
-					const ctx = screen.getContext('2d');
-					if (screen.width !== bitmap.width || screen.height !== bitmap.height) {
-						screen.width = bitmap.width;
-						screen.height = bitmap.height;
-					}
-					ctx.drawImage(bitmap, 0, 0);
-				});
+                const frame = event.data;
+                renderQueue = renderQueue.then(() => renderFrame(frame)).catch(err => {
+                    console.warn('Frame error, requesting refresh:', err);
+                    sendMessage('refresh');
+                });
                 
                 const now = Date.now(); frameCount++;
                 if (now - lastFrameTime >= 2000) {
@@ -739,6 +1026,46 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             ws.onerror = function(error) { console.error('WebSocket error:', error); updateStatus(false); };
         }
 
+        // Frames binaires : JPEG brut (0xFF) ou format tiles (1 = keyframe, 2 = delta)
+        function renderFrame(buffer) {
+            const ctx = screen.getContext('2d');
+            const bytes = new Uint8Array(buffer);
+            if (bytes[0] === 0xFF) {
+                return createImageBitmap(new Blob([buffer], { type: 'image/jpeg' })).then(bitmap => {
+                    if (screen.width !== bitmap.width || screen.height !== bitmap.height) {
+                        screen.width = bitmap.width;
+                        screen.height = bitmap.height;
+                    }
+                    ctx.drawImage(bitmap, 0, 0);
+                });
+            }
+
+            const view = new DataView(buffer);
+            const width = view.getUint16(1), height = view.getUint16(3);
+            if (bytes[0] === 1) {
+                return createImageBitmap(new Blob([buffer.slice(5)], { type: 'image/jpeg' })).then(bitmap => {
+                    if (screen.width !== width || screen.height !== height) {
+                        screen.width = width;
+                        screen.height = height;
+                    }
+                    ctx.drawImage(bitmap, 0, 0);
+                });
+            }
+            if (bytes[0] !== 2) return Promise.reject(new Error('unknown frame type ' + bytes[0]));
+            if (screen.width !== width || screen.height !== height) return Promise.reject(new Error('delta for a different frame size'));
+
+            const count = view.getUint16(5);
+            const tiles = [];
+            let offset = 7;
+            for (let i = 0; i < count; i++) {
+                const x = view.getUint16(offset), y = view.getUint16(offset + 2), size = view.getUint32(offset + 8);
+                const blob = new Blob([buffer.slice(offset + 12, offset + 12 + size)], { type: 'image/jpeg' });
+                tiles.push(createImageBitmap(blob).then(bitmap => ({ x: x, y: y, bitmap: bitmap })));
+                offset += 12 + size;
+            }
+            return Promise.all(tiles).then(decoded => decoded.forEach(t => ctx.drawImage(t.bitmap, t.x, t.y)));
+        }
+
         function disconnect() {
             if (ws) {
 				manualDisconnect = true;
@@ -763,10 +1090,11 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
         function changeScreen(screenIndex) {
             currentScreen = screenIndex;
             document.querySelectorAll('.screen-btn').forEach(btn => btn.classList.remove('active'));
-            document.querySelector('[data-screen="' + screenIndex + '"]').classList.add('active');
+            const screenBtn = document.querySelector('[data-screen="' + screenIndex + '"]');
+            if (screenBtn) screenBtn.classList.add('active');
             const screenName = screenIndex === 'all' ? 'All Screens' : 'Screen ' + (parseInt(screenIndex) + 1);
             document.getElementById('current-screen').textContent = 'Current: ' + screenName;
-            if (ws && ws.readyState === WebSocket.OPEN) ws.send('screen:' + screenIndex);
+            sendMessage('screen', { screen: screenIndex === 'all' ? -1 : screenIndex });
         }
 
         function setFPS(fps) {
@@ -774,45 +1102,301 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             document.querySelectorAll('.fps-btn').forEach(btn => btn.classList.remove('active'));
             document.querySelector('[data-fps="' + fps + '"]').classList.add('active');
             if (ws && ws.readyState === WebSocket.OPEN) {
-                ws.send('fps:' + fps);
+                sendMessage('fps', { fps: fps });
                 frameCount = 0; lastFrameTime = Date.now(); fpsDisplay = 0;
                 document.getElementById('fps-info').textContent = 'FPS: ' + fps + ' (measuring...)';
             } else document.getElementById('fps-info').textContent = 'FPS: ' + fps;
         }
 
+        function setQuality(quality) {
+            currentQuality = quality;
+            document.querySelectorAll('.quality-btn').forEach(btn => btn.classList.remove('active'));
+            document.querySelector('[data-quality="' + quality + '"]').classList.add('active');
+            sendMessage('quality', { quality: quality === 'auto' ? 0 : quality });
+        }
+
         function toggleControl() {
             controlEnabled = !controlEnabled;
             if (controlEnabled) {
                 controlBtn.textContent = 'Disable Control'; controlBtn.classList.add('enabled');
                 controlIndicator.style.display = 'block'; document.getElementById('control-status').textContent = 'Control: Enabled';
+                imeInput.focus();
+                sendMessage('control', { enabled: true });
             } else {
                 controlBtn.textContent = 'Enable Control'; controlBtn.classList.remove('enabled');
                 controlIndicator.style.display = 'none'; document.getElementById('control-status').textContent = 'Control: Disabled';
+                imeInput.blur();
+                if (pointerLocked) document.exitPointerLock();
+                resetInputState();
+                if (Object.keys(uploads).length) cancelUploads();
+                sendMessage('control', { enabled: false });
+            }
+        }
+
+        // Presse-papiers riche : image/png et text/html passent en base64 dans
+        // "data", le texte brut reste dans "text"
+        const CLIPBOARD_MIMES = ['image/png', 'text/html'];
+
+        function blobToBase64(blob) {
+            return new Promise((resolve, reject) => {
+                const reader = new FileReader();
+                reader.onload = () => resolve(reader.result.slice(reader.result.indexOf(',') + 1));
+                reader.onerror = () => reject(reader.error);
+                reader.readAsDataURL(blob);
+            });
+        }
+
+        async function readBrowserClipboard() {
+            if (navigator.clipboard.read) {
+                try {
+                    for (const item of await navigator.clipboard.read()) {
+                        const mime = CLIPBOARD_MIMES.find(m => item.types.includes(m));
+                        if (!mime) continue;
+                        const payload = { action: 'set', mime: mime, text: '', data: await blobToBase64(await item.getType(mime)) };
+                        if (item.types.includes('text/plain')) payload.text = await (await item.getType('text/plain')).text();
+                        return payload;
+                    }
+                } catch (err) {
+                    console.warn('Rich clipboard unavailable, falling back to text:', err);
+                }
             }
+            return { action: 'set', text: await navigator.clipboard.readText() };
+        }
+
+        function writeBrowserClipboard(data) {
+            if (!data.mime || data.mime === 'text/plain' || !window.ClipboardItem) {
+                return navigator.clipboard.writeText(data.text || '');
+            }
+            const bytes = Uint8Array.from(atob(data.data), c => c.charCodeAt(0));
+            const items = { [data.mime]: new Blob([bytes], { type: data.mime }) };
+            if (data.text) items['text/plain'] = new Blob([data.text], { type: 'text/plain' });
+            return navigator.clipboard.write([new ClipboardItem(items)]);
+        }
+
+        // Sens autorisés par la politique du serveur (-clipboard)
+        function clipboardAllows(direction) {
+            const policy = (serverInfo && serverInfo.clipboardPolicy) || 'both';
+            return policy === 'both' || policy === direction;
+        }
+
+        function requestVMClipboard() {
+            if (clipboardAllows('vm-to-browser')) sendControlEvent('clipboard', { action: 'get' });
         }
 
         function syncClipboard() {
             if (!controlEnabled || !ws || ws.readyState !== WebSocket.OPEN) return;
+            if (!clipboardAllows('browser-to-vm')) {
+                requestVMClipboard();
+                return;
+            }
             
-            navigator.clipboard.readText().then(text => {
-                sendControlEvent('clipboard', { action: 'set', text: text });
+            readBrowserClipboard().then(payload => {
+                sendClipboardPayload(payload);
                 console.log('Clipboard sent to VM');
                 
                 setTimeout(() => {
-                    sendControlEvent('clipboard', { action: 'get' });
+                    requestVMClipboard();
                     console.log('Clipboard requested from VM');
                 }, 100);
             }).catch(err => {
                 console.warn('Cannot read clipboard:', err);
-                sendControlEvent('clipboard', { action: 'get' });
+                requestVMClipboard();
+            });
+        }
+
+        // Sélection PRIMARY (X11) : surligner du texte dans la VM l'ajoute à
+        // l'historique, et les envois vers la VM la remplissent aussi pour le clic du milieu
+        let primaryEnabled = false;
+        const primaryBtn = document.getElementById('primaryBtn'), historySelect = document.getElementById('history-select');
+        function togglePrimary() {
+            primaryEnabled = !primaryEnabled;
+            primaryBtn.classList.toggle('active', primaryEnabled);
+            sendMessage('clipboard', { action: primaryEnabled ? 'watch' : 'unwatch', selection: 'primary' });
+        }
+
+        function sendClipboardPayload(payload) {
+            sendControlEvent('clipboard', payload);
+            if (primaryEnabled) sendControlEvent('clipboard', Object.assign({}, payload, { selection: 'primary' }));
+        }
+
+        // Historique des dernières copies de la session, gardé par le serveur
+        function requestClipboardHistory() {
+            sendMessage('clipboard', { action: 'history' });
+        }
+
+        function showClipboardHistory(entries) {
+            historySelect.innerHTML = '';
+            historySelect.add(new Option(entries.length ? 'Paste from history…' : 'History is empty', ''));
+            entries.forEach(entry => {
+                const origin = (entry.source === 'vm' ? 'VM' : 'Browser') + (entry.selection === 'primary' ? ' (PRIMARY)' : '');
+                const summary = entry.preview || (entry.mime + ', ' + entry.size + ' bytes');
+                historySelect.add(new Option(origin + ': ' + summary.replace(/\s+/g, ' '), entry.id));
+            });
+            historySelect.style.display = 'inline-block';
+        }
+
+        function applyClipboardHistory(select) {
+            const id = Number(select.value);
+            select.style.display = 'none';
+            if (!id) return;
+            sendControlEvent('clipboard', { action: 'apply', id: id });
+            if (primaryEnabled) sendControlEvent('clipboard', { action: 'apply', id: id, selection: 'primary' });
+        }
+
+        // Saisie du presse-papiers touche par touche ; un second clic l'annule
+        const TYPE_DELAY_MS = 30;
+        let typing = false;
+        function typeClipboard() {
+            if (typing) {
+                sendControlEvent('typeText', { action: 'cancel' });
+                return;
+            }
+            if (!controlEnabled) { alert('Enable control first'); return; }
+            navigator.clipboard.readText().then(text => {
+                if (!text) return;
+                sendControlEvent('typeText', { action: 'start', text: text, delay: TYPE_DELAY_MS });
+            }).catch(err => {
+                console.warn('Cannot read clipboard for typing:', err);
             });
         }
 
+        function handleTypeStatus(st) {
+            const typeBtn = document.getElementById('typeBtn');
+            typing = st.state === 'progress';
+            if (typing) {
+                typeBtn.textContent = 'Typing ' + st.typed + '/' + st.total + ' (cancel)';
+            } else {
+                typeBtn.textContent = 'Type Clipboard';
+                if (st.state !== 'done') console.warn('Typing ' + st.state + ' after ' + st.typed + '/' + st.total + (st.message ? ': ' + st.message : ''));
+            }
+        }
+
         function sendControlEvent(type, data) {
-            if (!controlEnabled || !ws || ws.readyState !== WebSocket.OPEN) return;
-            ws.send(JSON.stringify({type: type, data: data}));
+            if (!controlEnabled) return;
+            sendMessage(type, data);
+        }
+
+        function sendMessage(type, data) {
+            if (!ws || ws.readyState !== WebSocket.OPEN) return;
+            ws.send(JSON.stringify(data === undefined ? {type: type} : {type: type, data: data}));
+        }
+
+        // Bandeau visible tant que le contrôle ou le presse-papiers est dégradé côté serveur
+        const degradedServices = {};
+        function setDegraded(service, degraded, message) {
+            if (degraded) degradedServices[service] = message || 'unavailable';
+            else delete degradedServices[service];
+            const banner = document.getElementById('degraded-banner');
+            const labels = { input: 'Remote control', clipboard: 'Clipboard' };
+            const parts = Object.keys(degradedServices).map(s => (labels[s] || s) + ' degraded: ' + degradedServices[s]);
+            banner.textContent = parts.join(' | ');
+            banner.style.display = parts.length ? 'block' : 'none';
+        }
+
+        // Capacités annoncées par le serveur : reconstruit la sélection d'écran
+        function handleHello(hello) {
+            serverInfo = hello;
+            setDegraded('input', !hello.input, 'not available on the server');
+            setDegraded('clipboard', !hello.clipboard, 'not available on the server');
+            primaryBtn.style.display = hello.primary && hello.clipboard ? '' : 'none';
+            document.getElementById('typeBtn').style.display = clipboardAllows('browser-to-vm') ? '' : 'none';
+            const container = document.getElementById('screen-buttons');
+            container.innerHTML = '';
+            const addButton = (label, value) => {
+                const btn = document.createElement('button');
+                btn.textContent = label;
+                btn.className = 'screen-btn' + (String(currentScreen) === String(value) ? ' active' : '');
+                btn.dataset.screen = value;
+                btn.title = value === 'all' ? 'All screens' : hello.screens[value].width + 'x' + hello.screens[value].height;
+                btn.onclick = () => changeScreen(value);
+                container.appendChild(btn);
+            };
+            addButton('All', 'all');
+            (hello.screens || []).forEach(s => addButton(String(s.index + 1), s.index));
+        }
+
+        // Envoi de fichiers : un fichier déposé sur l'écran part par morceaux,
+        // chacun envoyé à réception de l'accusé du précédent. Un envoi coupé
+        // reprend à la reconnexion, à l'offset renvoyé par le serveur.
+        const UPLOAD_CHUNK = 512 * 1024;
+        const uploads = {};
+
+        function uploadID() {
+            return Array.from(crypto.getRandomValues(new Uint8Array(16)), b => b.toString(16).padStart(2, '0')).join('');
+        }
+
+        function showUploadStatus(text) {
+            document.getElementById('upload-info').style.display = text ? '' : 'none';
+            document.getElementById('upload-status').textContent = text;
+            document.getElementById('uploadCancelBtn').style.display = Object.keys(uploads).length ? '' : 'none';
+        }
+
+        function startUpload(file) {
+            if (serverInfo.uploadMax && file.size > serverInfo.uploadMax) {
+                showUploadStatus(file.name + ': too large (max ' + serverInfo.uploadMax + ' bytes)');
+                return;
+            }
+            const id = uploadID();
+            uploads[id] = file;
+            sendMessage('upload', { action: 'start', id: id, name: file.name, size: file.size });
         }
 
+        function resumeUploads() {
+            for (const id in uploads) {
+                sendMessage('upload', { action: 'start', id: id, name: uploads[id].name, size: uploads[id].size });
+            }
+        }
+
+        function clearUploads() {
+            for (const id in uploads) delete uploads[id];
+        }
+
+        function cancelUploads() {
+            for (const id in uploads) sendMessage('upload', { action: 'cancel', id: id });
+            clearUploads();
+            showUploadStatus('Upload cancelled');
+        }
+
+        function sendUploadChunk(id, offset) {
+            const file = uploads[id];
+            blobToBase64(file.slice(offset, offset + UPLOAD_CHUNK)).then(data => {
+                if (uploads[id] === file) sendMessage('upload', { action: 'chunk', id: id, offset: offset, data: data });
+            }).catch(err => {
+                console.warn('Cannot read ' + file.name + ':', err);
+                sendMessage('upload', { action: 'cancel', id: id });
+                delete uploads[id];
+                showUploadStatus(file.name + ': cannot read file');
+            });
+        }
+
+        // Les envois multipart (POST /upload?session=...) n'ont pas de taille
+        // connue : seuls les octets reçus sont affichés
+        function handleUploadStatus(st) {
+            const name = st.name || (uploads[st.id] && uploads[st.id].name) || st.id;
+            if (st.state === 'ready' || st.state === 'progress') {
+                const progress = st.size >= 0 ? Math.floor(100 * st.received / Math.max(st.size, 1)) + '%' : st.received + ' bytes';
+                if (uploads[st.id]) sendUploadChunk(st.id, st.received);
+                showUploadStatus('Uploading ' + name + ': ' + progress);
+                return;
+            }
+            delete uploads[st.id];
+            if (st.state === 'done') showUploadStatus('Uploaded ' + name + ' (' + st.size + ' bytes)');
+            else if (st.state === 'failed') showUploadStatus(name + ': upload failed - ' + (st.message || 'unknown error'));
+            else showUploadStatus(name + ': upload cancelled');
+        }
+
+        screen.addEventListener('dragover', function(e) {
+            if (!e.dataTransfer.types.includes('Files')) return;
+            e.preventDefault();
+            e.dataTransfer.dropEffect = controlEnabled && serverInfo && serverInfo.upload ? 'copy' : 'none';
+        });
+        screen.addEventListener('drop', function(e) {
+            e.preventDefault();
+            if (!serverInfo || !serverInfo.upload) { showUploadStatus('File upload is disabled on the server'); return; }
+            if (!controlEnabled) { showUploadStatus('Enable control to upload files'); return; }
+            for (const file of e.dataTransfer.files) startUpload(file);
+        });
+
         function getImageCoordinates(e) {
 			const rect = screen.getBoundingClientRect();
 			const scaleX = screen.width / rect.width;
@@ -830,10 +1414,39 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+        // Mode souris relative (Pointer Lock API) : les déplacements sont
+        // cumulés et envoyés au plus une fois par frame d'affichage
+        let pointerLocked = false, relDX = 0, relDY = 0, relPending = false;
+        function togglePointerLock() {
+            if (pointerLocked) { document.exitPointerLock(); return; }
+            if (!controlEnabled) { alert('Enable control first'); return; }
+            screen.requestPointerLock();
+        }
+        document.addEventListener('pointerlockchange', function() {
+            pointerLocked = document.pointerLockElement === screen;
+            document.getElementById('lockBtn').textContent = pointerLocked ? 'Exit Pointer Lock' : 'Pointer Lock';
+            relDX = relDY = 0;
+        });
+        function flushRelative() {
+            relPending = false;
+            if (!pointerLocked || (relDX === 0 && relDY === 0)) return;
+            sendControlEvent('mouseRelative', {dx: relDX, dy: relDY, action: 'move'});
+            relDX = relDY = 0;
+        }
+        function mouseButtonName(e) {
+            return e.button === 0 ? 'left' : e.button === 2 ? 'right' : 'middle';
+        }
+
         // Événements souris - VERSION CORRIGÉE selon ChatGPT
         screen.addEventListener('mousedown', function(e) {
             if (!controlEnabled) return; 
             e.preventDefault();
+            if (pointerLocked) {
+                flushRelative();
+                sendControlEvent('mouseRelative', {button: mouseButtonName(e), action: 'down'});
+                return;
+            }
+            imeInput.focus();
             const coords = getImageCoordinates(e);
             dragButton = e.button === 0 ? 'left' : e.button === 2 ? 'right' : 'middle';
             isMouseDown = true;
@@ -843,6 +1456,11 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
         screen.addEventListener('mouseup', function(e) {
             if (!controlEnabled) return; 
             e.preventDefault();
+            if (pointerLocked) {
+                flushRelative();
+                sendControlEvent('mouseRelative', {button: mouseButtonName(e), action: 'up'});
+                return;
+            }
             const coords = getImageCoordinates(e);
             const button = e.button === 0 ? 'left' : e.button === 2 ? 'right' : 'middle';
             sendControlEvent('mouse', {x: coords.x, y: coords.y, button: button, action: 'up'});
@@ -853,6 +1471,11 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
         let lastMouseMove = 0;
 		screen.addEventListener('mousemove', function(e) {
 			if (!controlEnabled) return; 
+			if (pointerLocked) {
+				relDX += e.movementX; relDY += e.movementY;
+				if (!relPending) { relPending = true; requestAnimationFrame(flushRelative); }
+				return;
+			}
 			const now = Date.now();
 			if (now - lastMouseMove < 16) return;
 			lastMouseMove = now;
@@ -869,30 +1492,90 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
         screen.addEventListener('wheel', function(e) {
             if (!controlEnabled) return; 
             e.preventDefault();
+            // Sans position absolue, défiler déplacerait le pointeur verrouillé
+            if (pointerLocked) return;
             const coords = getImageCoordinates(e);
-            sendControlEvent('mouse', {x: coords.x, y: coords.y, button: 'wheel', action: 'scroll', scroll: e.deltaY > 0 ? -1 : 1});
+            sendControlEvent('scroll', {x: coords.x, y: coords.y, deltaX: e.deltaX, deltaY: e.deltaY, deltaMode: e.deltaMode});
+        });
+
+        // Événements tactiles : la liste complète des doigts posés est envoyée,
+        // le serveur en déduit tap, appui long, glisser et défilement
+        let lastTouchMove = 0;
+        function sendTouch(e) {
+            if (!controlEnabled) return;
+            e.preventDefault();
+            const action = e.type.replace('touch', '');
+            if (action === 'move') {
+                const now = Date.now();
+                if (now - lastTouchMove < 16) return;
+                lastTouchMove = now;
+            }
+            const touches = Array.from(e.touches).map(t => {
+                const coords = getImageCoordinates(t);
+                return {id: t.identifier, x: Math.max(0, coords.x), y: Math.max(0, coords.y)};
+            });
+            sendControlEvent('touch', {action: action, touches: touches});
+        }
+        ['touchstart', 'touchmove', 'touchend', 'touchcancel'].forEach(type => {
+            screen.addEventListener(type, sendTouch, {passive: false});
+        });
+
+        // Caractère imprimable hors raccourci : envoyé en texte Unicode, ce qui
+        // respecte la disposition du navigateur (AZERTY, AltGr, touches mortes)
+        function isTextKey(e) {
+            if (!serverInfo || !serverInfo.text || [...e.key].length !== 1) return false;
+            const altGraph = e.getModifierState && e.getModifierState('AltGraph');
+            return !e.metaKey && (altGraph || (!e.ctrlKey && !e.altKey));
+        }
+
+        // Windows envoie AltGr comme ControlLeft puis AltRight : le Ctrl déjà
+        // transmis est relâché dans la VM avant le caractère, et son keyup ignoré
+        let ctrlLeftDown = false;
+        function releaseAltGraphCtrl(e) {
+            if (!ctrlLeftDown || !(e.getModifierState && e.getModifierState('AltGraph'))) return;
+            ctrlLeftDown = false;
+            textKeys.add('ControlLeft');
+            sendControlEvent('keyboard', {key: 'Control', code: 'ControlLeft', action: 'up', ctrl: false, alt: false, shift: false, meta: false});
+        }
+
+        // Touches qui ne servent qu'à composer un caractère : jamais transmises
+        function isComposeKey(e) {
+            return e.key === 'Dead' || e.key === 'Process' || (e.key === 'AltGraph' && serverInfo && serverInfo.text);
+        }
+
+        // Saisie composée (IME, touches mortes) : le texte final est envoyé tel quel
+        imeInput.addEventListener('compositionend', function(e) {
+            if (controlEnabled && serverInfo && serverInfo.text && e.data) sendControlEvent('text', {text: e.data});
+            imeInput.value = '';
+        });
+        imeInput.addEventListener('input', function(e) {
+            if (e.isComposing || e.inputType === 'insertCompositionText') return;
+            if (controlEnabled && serverInfo && serverInfo.text && e.data && e.inputType === 'insertText') sendControlEvent('text', {text: e.data});
+            imeInput.value = '';
         });
 
         // Événements clavier avec Ctrl+C/Ctrl+V automatique
         document.addEventListener('keydown', function(e) {
+            if (controlEnabled && (e.isComposing || e.keyCode === 229)) return;
+            if (controlEnabled) releaseAltGraphCtrl(e);
             if (controlEnabled && e.ctrlKey) {
                 if (e.key === 'c' || e.key === 'C') {
-                    sendControlEvent('keyboard', {key: e.key, action: 'down', ctrl: true, alt: false, shift: false});
+                    sendControlEvent('keyboard', {key: e.key, code: e.code, action: 'down', ctrl: true, alt: false, shift: false});
                     setTimeout(() => {
-                        sendControlEvent('keyboard', {key: e.key, action: 'up', ctrl: true, alt: false, shift: false});
-                        setTimeout(() => {
-                            sendControlEvent('clipboard', { action: 'get' });
-                        }, 100);
+                        sendControlEvent('keyboard', {key: e.key, code: e.code, action: 'up', ctrl: true, alt: false, shift: false});
+                        setTimeout(requestVMClipboard, 100);
                     }, 50);
                     e.preventDefault();
                     return;
                 } else if (e.key === 'v' || e.key === 'V') {
-                    navigator.clipboard.readText().then(text => {
-                        sendControlEvent('clipboard', { action: 'set', text: text });
+                    const upload = clipboardAllows('browser-to-vm')
+                        ? readBrowserClipboard().then(sendClipboardPayload)
+                        : Promise.resolve();
+                    upload.then(() => {
                         setTimeout(() => {
-                            sendControlEvent('keyboard', {key: e.key, action: 'down', ctrl: true, alt: false, shift: false});
+                            sendControlEvent('keyboard', {key: e.key, code: e.code, action: 'down', ctrl: true, alt: false, shift: false});
                             setTimeout(() => {
-                                sendControlEvent('keyboard', {key: e.key, action: 'up', ctrl: true, alt: false, shift: false});
+                                sendControlEvent('keyboard', {key: e.key, code: e.code, action: 'up', ctrl: true, alt: false, shift: false});
                             }, 50);
                         }, 100);
                     }).catch(err => {
@@ -917,16 +1600,27 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
                 return;
             }
             
+            if (controlEnabled && isTextKey(e)) {
+                e.preventDefault();
+                textKeys.add(e.code);
+                sendControlEvent('text', {text: e.key});
+                return;
+            }
+            if (controlEnabled && isComposeKey(e)) return;
+
             if (controlEnabled && !e.repeat) {
                 e.preventDefault();
-                sendControlEvent('keyboard', {key: e.key, action: 'down', ctrl: e.ctrlKey, alt: e.altKey, shift: e.shiftKey});
+                if (e.code === 'ControlLeft') ctrlLeftDown = true;
+                sendControlEvent('keyboard', {key: e.key, code: e.code, action: 'down', ctrl: e.ctrlKey, alt: e.altKey, shift: e.shiftKey, meta: e.metaKey});
             }
         });
 
         document.addEventListener('keyup', function(e) {
+            if (e.code === 'ControlLeft') ctrlLeftDown = false;
+            if (controlEnabled && (textKeys.delete(e.code) || isComposeKey(e))) return;
             if (controlEnabled) {
                 e.preventDefault();
-                sendControlEvent('keyboard', {key: e.key, action: 'up', ctrl: e.ctrlKey, alt: e.altKey, shift: e.shiftKey});
+                sendControlEvent('keyboard', {key: e.key, code: e.code, action: 'up', ctrl: e.ctrlKey, alt: e.altKey, shift: e.shiftKey, meta: e.metaKey});
             }
         });
 
@@ -941,8 +1635,22 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             }
         });
 
+        // Le navigateur ne verra pas les keyup/mouseup une fois la fenêtre
+        // inactive : le serveur relâche tout ce qui était enfoncé
+        function resetInputState() {
+            isMouseDown = false; dragButton = null;
+            textKeys.clear(); ctrlLeftDown = false;
+        }
+        function releaseAll() {
+            if (!controlEnabled) return;
+            resetInputState();
+            sendMessage('release');
+        }
+        window.addEventListener('blur', releaseAll);
+        document.addEventListener('visibilitychange', function() { if (document.hidden) releaseAll(); });
+
         screen.ondblclick = function(e) { if (!controlEnabled) toggleFullscreen(); };
-        screen.onclick = function(e) { if (!controlEnabled && !isFullscreen && ws && ws.readyState === WebSocket.OPEN) ws.send('refresh'); };
+        screen.onclick = function(e) { if (!controlEnabled && !isFullscreen) sendMessage('refresh'); };
         screen.onload = updateImageInfo;
         window.onload = function() { updateStatus(false); connect(); };
 
@@ -961,7 +1669,75 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	numScreens := screenshot.NumActiveDisplays()
+	captureKind := flag.String("capture", "screenshot", "backend de capture: screenshot, synthetic, replay")
+	syntheticDisplays := flag.String("synthetic-displays", "1920x1080", "écrans de la mire synthétique, ex: 1920x1080,1280x1024")
+	replayDir := flag.String("replay-dir", "", "dossier d'images rejouées par le backend replay")
+	inputKind := flag.String("input", "auto", "backend d'entrée Linux: auto, xtest, uinput, xdotool")
+	inputRate := flag.Int("input-rate", 200, "événements d'entrée max par seconde et par client (0 = illimité)")
+	displayScale := flag.String("display-scale", "", "pixels capturés par unité d'entrée (DPI), ex: 1.5 ou 2,1 par écran")
+	frameScale := flag.Float64("frame-scale", 1, "réduction des images envoyées, de 0.25 à 1")
+	clipboardMode := flag.String("clipboard", clipboardBidirectional, "presse-papiers: both, vm-to-browser, browser-to-vm, off")
+	clipboardMax := flag.Int("clipboard-max", maxClipboardSize, "taille maximale d'un contenu de presse-papiers, en octets")
+	clipboardAudit := flag.String("clipboard-audit", "", "fichier d'audit des refus et masquages (défaut: log)")
+	clipboardHistory := flag.Int("clipboard-history", 20, "copies gardées dans l'historique de chaque session (0 = aucun)")
+	clipboardHistoryMax := flag.Int("clipboard-history-max", clipboardHistoryBudget, "octets gardés au plus par l'ensemble des historiques (0 = illimité)")
+	var clipboardRedact redactFlag
+	flag.Var(&clipboardRedact, "clipboard-redact", "expression régulière masquée dans le presse-papiers (répétable)")
+	uploadDir := flag.String("upload-dir", "", "dossier où écrire les fichiers déposés dans le navigateur (vide = désactivé)")
+	uploadMax := flag.Int64("upload-max", 1<<30, "taille maximale d'un fichier envoyé, en octets")
+	uploadPending := flag.Int64("upload-pending-max", 4<<30, "octets réservés au plus par l'ensemble des envois en cours")
+	keyframeInterval := flag.Duration("keyframe-interval", 10*time.Second, "intervalle des keyframes complètes en mode tiles (0 = jamais)")
+	flag.Parse()
+
+	capturer, err := newCapturer(*captureKind, *syntheticDisplays, *replayDir)
+	if err != nil {
+		fmt.Printf("Erreur capture: %v\n", err)
+		os.Exit(1)
+	}
+	if *captureKind != "screenshot" {
+		fmt.Printf("Backend de capture: %s\n", *captureKind)
+	}
+
+	dpiScales, err := parseDisplayScales(*displayScale)
+	if err != nil {
+		fmt.Printf("Erreur -display-scale: %v\n", err)
+		os.Exit(1)
+	}
+	if *frameScale < 0.25 || *frameScale > 1 {
+		fmt.Printf("Erreur -frame-scale: %v hors de 0.25 à 1\n", *frameScale)
+		os.Exit(1)
+	}
+	policy, err := newClipboardPolicy(*clipboardMode, *clipboardMax)
+	if err != nil {
+		fmt.Printf("Erreur politique presse-papiers: %v\n", err)
+		os.Exit(1)
+	}
+	policy.redact = clipboardRedact
+	if *clipboardAudit != "" {
+		auditFile, err := os.OpenFile(*clipboardAudit, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			fmt.Printf("Erreur fichier d'audit: %v\n", err)
+			os.Exit(1)
+		}
+		defer auditFile.Close()
+		policy.audit = auditFile
+	}
+
+	var uploads *uploadManager
+	if *uploadDir != "" {
+		uploads, err = newUploadManager(*uploadDir, *uploadMax, *uploadPending)
+		if err != nil {
+			fmt.Printf("Erreur envoi de fichiers: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Envoi de fichiers vers %s (max %d octets)\n", *uploadDir, *uploadMax)
+	}
+
+	geometry := newDisplayGeometry(capturer)
+	geometry.dpiScales = dpiScales
+	geometry.frameScale = *frameScale
+
+	numScreens := capturer.NumDisplays()
 	switch runtime.GOOS {
 	case "windows":
 		fmt.Printf("Windows détecté - %d écran(s)\n", numScreens)
@@ -971,12 +1747,13 @@ func main() {
 		fmt.Println("  -> Ou depuis un PowerShell/CMD administrateur")
 	case "linux":
 		fmt.Printf("Linux détecté - %d écran(s)\n", numScreens)
-		fmt.Println("Dépendances pour contrôle : sudo apt install xdotool xclip")
-		if _, err := exec.LookPath("xdotool"); err != nil {
-			fmt.Println("ATTENTION: xdotool non trouvé - le contrôle ne fonctionnera pas")
-			fmt.Println("Installation: sudo apt install xdotool")
+		fmt.Println("Dépendances pour contrôle : XTest (serveur X), /dev/uinput (Wayland, console) ou sudo apt install xdotool xclip")
+		if backend, err := newInputBackend(*inputKind, geometry.inputBounds); err != nil {
+			fmt.Printf("ATTENTION: aucun backend d'entrée (%v) - le contrôle ne fonctionnera pas\n", err)
+			fmt.Println("Installation: sudo apt install xdotool, ou accès en écriture à /dev/uinput")
 		} else {
-			fmt.Println("xdotool trouvé - contrôle disponible")
+			linuxInput = backend
+			fmt.Printf("Backend d'entrée: %s - contrôle disponible\n", backend.Name())
 		}
 		if _, err := exec.LookPath("xclip"); err != nil {
 			fmt.Println("ATTENTION: xclip non trouvé - le clipboard ne fonctionnera pas")
@@ -999,18 +1776,26 @@ func main() {
 	}
 
 	for i := 0; i < numScreens; i++ {
-		bounds := screenshot.GetDisplayBounds(i)
+		bounds := capturer.DisplayBounds(i)
 		fmt.Printf("   Écran %d: %dx%d à (%d,%d)\n", i, bounds.Dx(), bounds.Dy(), bounds.Min.X, bounds.Min.Y)
 	}
 
-	streamer := NewScreenStreamer()
+	streamer := NewScreenStreamer(capturer)
+	streamer.geometry = geometry
+	streamer.setClipboardPolicy(policy)
+	streamer.clipboardHistory = *clipboardHistory
+	streamer.clipboardBudget.max = *clipboardHistoryMax
+	streamer.keyframeInterval = *keyframeInterval
+	streamer.inputRate = *inputRate
+	streamer.uploads = uploads
 	http.HandleFunc("/", serveHTML)
 	http.HandleFunc("/ws", streamer.handleWebSocket)
-	go streamer.startStreaming()
+	http.HandleFunc("/stats", streamer.handleStats)
+	http.HandleFunc("/upload", streamer.handleUpload)
 
 	port := "8080"
-	if len(os.Args) > 1 {
-		port = os.Args[1]
+	if flag.NArg() > 0 {
+		port = flag.Arg(0)
 	}
 
 	fmt.Printf("Serveur démarré sur http://localhost:%s\n", port)
@@ -0,0 +1,660 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// Version 1 : commandes texte historiques ("screen:1", "fps:30", "refresh")
+// et événements JSON non typés. Version 2 : uniquement des messages JSON
+// {"type": ..., "data": ...}, ouverts par un échange "hello".
+const (
+	protocolVersion       = 2
+	legacyProtocolVersion = 1
+	serverName            = "vm-desktop-streamer"
+)
+
+var supportedCodecs = []string{"jpeg", "tiles"}
+
+// ControlEvent est l'enveloppe de tous les messages JSON. Data est décodé en
+// fonction de Type dans la structure correspondante ci-dessous.
+type ControlEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Messages navigateur -> serveur
+
+type HelloEvent struct {
+	Version int      `json:"version"`
+	Codecs  []string `json:"codecs,omitempty"`
+}
+
+type ScreenEvent struct {
+	Screen int `json:"screen"` // -1 = tous les écrans
+}
+
+type FPSEvent struct {
+	FPS int `json:"fps"`
+}
+
+type QualityEvent struct {
+	Quality int `json:"quality"` // 0 = automatique selon le FPS
+}
+
+type CodecEvent struct {
+	Codec string `json:"codec"`
+}
+
+type MouseEvent struct {
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Button string `json:"button"`
+	Action string `json:"action"`
+	Scroll int    `json:"scroll,omitempty"`
+}
+
+// ScrollEvent reprend les deltas de l'événement wheel du navigateur ;
+// DeltaMode : 0 = pixels, 1 = lignes, 2 = pages.
+type ScrollEvent struct {
+	X         int     `json:"x"`
+	Y         int     `json:"y"`
+	DeltaX    float64 `json:"deltaX"`
+	DeltaY    float64 `json:"deltaY"`
+	DeltaMode int     `json:"deltaMode"`
+}
+
+// RelativeMouseEvent est envoyé en mode pointer lock : DX, DY sont les
+// movementX / movementY cumulés du navigateur.
+type RelativeMouseEvent struct {
+	DX     int    `json:"dx"`
+	DY     int    `json:"dy"`
+	Button string `json:"button,omitempty"`
+	Action string `json:"action"` // "move", "down", "up"
+}
+
+// TouchEvent reprend un événement tactile du navigateur : Touches est la
+// liste complète des doigts encore posés après l'événement.
+type TouchEvent struct {
+	Action  string       `json:"action"` // "start", "move", "end", "cancel"
+	Touches []TouchPoint `json:"touches"`
+}
+
+type TouchPoint struct {
+	ID int `json:"id"`
+	X  int `json:"x"`
+	Y  int `json:"y"`
+}
+
+// KeyboardEvent reprend key (caractère ou nom de touche) et code (touche
+// physique) de l'événement clavier du navigateur.
+type KeyboardEvent struct {
+	Key    string `json:"key"`
+	Code   string `json:"code,omitempty"`
+	Action string `json:"action"`
+	Ctrl   bool   `json:"ctrl"`
+	Alt    bool   `json:"alt"`
+	Shift  bool   `json:"shift"`
+	Meta   bool   `json:"meta"`
+}
+
+// TextEvent injecte du texte Unicode indépendamment de la disposition
+// clavier de la VM : caractères accentués, touches mortes, saisie IME.
+type TextEvent struct {
+	Text string `json:"text"`
+}
+
+// TypeTextEvent saisit un texte touche par touche, pour les applications
+// qui ignorent le presse-papiers (installeurs, consoles, mots de passe).
+type TypeTextEvent struct {
+	Action string `json:"action"` // "start", "cancel"
+	Text   string `json:"text,omitempty"`
+	Delay  int    `json:"delay,omitempty"` // ms entre deux caractères, 0 = défaut
+}
+
+// ClipboardEvent transporte du texte brut dans Text. Les autres formats
+// (Mime "image/png", "text/html") sont encodés en base64 dans Data, Text
+// gardant alors l'équivalent texte quand il existe.
+type ClipboardEvent struct {
+	Text      string `json:"text"`
+	Action    string `json:"action"`         // "get", "set", "content", "watch", "unwatch", "history", "apply"
+	Mime      string `json:"mime,omitempty"` // "" = text/plain
+	Data      string `json:"data,omitempty"`
+	Selection string `json:"selection,omitempty"` // "" = "clipboard", "primary"
+	ID        uint64 `json:"id,omitempty"`        // entrée d'historique pour "apply"
+}
+
+// ClipboardHistoryEvent répond à un "history" : les dernières copies de la
+// session, de la plus récente à la plus ancienne.
+type ClipboardHistoryEvent struct {
+	Entries []ClipboardHistoryEntry `json:"entries"`
+}
+
+type ClipboardHistoryEntry struct {
+	ID        uint64 `json:"id"`
+	Selection string `json:"selection"`
+	Source    string `json:"source"` // "vm", "browser"
+	Mime      string `json:"mime"`
+	Preview   string `json:"preview"`
+	Size      int    `json:"size"`
+	Time      string `json:"time"`
+}
+
+// UploadEvent envoie un fichier par morceaux : "start" ouvre l'envoi (ou le
+// reprend après une reconnexion), chaque "chunk" porte Data en base64 à
+// partir de Offset.
+type UploadEvent struct {
+	Action string `json:"action"` // "start", "chunk", "cancel"
+	ID     string `json:"id"`     // choisi par le navigateur, stable entre reconnexions
+	Name   string `json:"name,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+	Data   string `json:"data,omitempty"`
+}
+
+// ControlStateEvent signale que le navigateur active ou retire le contrôle ;
+// au retrait, tout ce qui est encore enfoncé est relâché.
+type ControlStateEvent struct {
+	Enabled bool `json:"enabled"`
+}
+
+// ReleaseEvent demande de relâcher touches et boutons enfoncés (fenêtre du
+// navigateur inactive).
+type ReleaseEvent struct{}
+
+type RefreshEvent struct{}
+
+type KeyframeEvent struct{}
+
+// Messages serveur -> navigateur
+
+type ScreenInfo struct {
+	Index  int `json:"index"`
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// ErrorEvent est renvoyé à l'expéditeur d'un message refusé ; la connexion
+// reste ouverte.
+type ErrorEvent struct {
+	Code    string `json:"code"`
+	Event   string `json:"event,omitempty"`
+	Message string `json:"message"`
+}
+
+// StatusEvent signale qu'un service (input, clipboard) vient de passer en
+// mode dégradé ou d'être rétabli.
+type StatusEvent struct {
+	Service  string `json:"service"`
+	Degraded bool   `json:"degraded"`
+	Message  string `json:"message,omitempty"`
+}
+
+// AckEvent confirme une action explicite du navigateur (ex: clipboard set).
+type AckEvent struct {
+	Event  string `json:"event"`
+	Action string `json:"action,omitempty"`
+}
+
+// TypeTextStatus rend compte de l'avancement d'une saisie typeText.
+type TypeTextStatus struct {
+	State   string `json:"state"` // "progress", "done", "cancelled", "failed"
+	Typed   int    `json:"typed"`
+	Total   int    `json:"total"`
+	Message string `json:"message,omitempty"`
+}
+
+// UploadStatus rend compte d'un envoi de fichier. "ready" demande au
+// navigateur d'envoyer la suite à partir de Received.
+type UploadStatus struct {
+	ID       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	State    string `json:"state"` // "ready", "progress", "done", "cancelled", "failed"
+	Received int64  `json:"received"`
+	Size     int64  `json:"size"` // -1 = inconnue (multipart)
+	Message  string `json:"message,omitempty"`
+}
+
+type HelloReply struct {
+	Version   int          `json:"version"`
+	Server    string       `json:"server"`
+	Session   uint64       `json:"session"`
+	Screens   []ScreenInfo `json:"screens"`
+	Codecs    []string     `json:"codecs"`
+	Input     bool         `json:"input"`
+	Clipboard bool         `json:"clipboard"`
+	// Sens autorisés : "both", "vm-to-browser", "browser-to-vm" ou "off"
+	ClipboardPolicy string `json:"clipboardPolicy"`
+	// Sélection PRIMARY (X11) synchronisable par "watch"
+	Primary bool `json:"primary"`
+	Text    bool `json:"text"`
+	// Envoi de fichiers activé (-upload-dir), taille maximale en octets
+	Upload    bool  `json:"upload"`
+	UploadMax int64 `json:"uploadMax,omitempty"`
+}
+
+// Codes d'erreur de ErrorEvent
+const (
+	errInvalidJSON     = "invalid_json"
+	errInvalidEvent    = "invalid_event"
+	errUnknownEvent    = "unknown_event"
+	errUnknownCommand  = "unknown_command"
+	errInternal        = "internal_error"
+	errInputFailed     = "input_failed"
+	errClipboardFail   = "clipboard_failed"
+	errRateLimited     = "rate_limited"
+	errClipboardDenied = "clipboard_denied"
+	errUploadFailed    = "upload_failed"
+)
+
+// Services dont l'état est remonté au navigateur par StatusEvent
+const (
+	serviceInput     = "input"
+	serviceClipboard = "clipboard"
+)
+
+type protocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *protocolError) Error() string {
+	return e.Message
+}
+
+func invalidEvent(format string, args ...interface{}) error {
+	return &protocolError{Code: errInvalidEvent, Message: fmt.Sprintf(format, args...)}
+}
+
+type validator interface {
+	validate() error
+}
+
+type eventSchema struct {
+	required []string
+	new      func() validator
+}
+
+// eventSchemas décrit, pour chaque type de message entrant, les champs
+// obligatoires de data et la structure dans laquelle le décoder.
+var eventSchemas = map[string]eventSchema{
+	"hello":         {required: []string{"version"}, new: func() validator { return &HelloEvent{} }},
+	"refresh":       {new: func() validator { return &RefreshEvent{} }},
+	"keyframe":      {new: func() validator { return &KeyframeEvent{} }},
+	"codec":         {required: []string{"codec"}, new: func() validator { return &CodecEvent{} }},
+	"screen":        {required: []string{"screen"}, new: func() validator { return &ScreenEvent{} }},
+	"fps":           {required: []string{"fps"}, new: func() validator { return &FPSEvent{} }},
+	"quality":       {required: []string{"quality"}, new: func() validator { return &QualityEvent{} }},
+	"mouse":         {required: []string{"x", "y", "action"}, new: func() validator { return &MouseEvent{} }},
+	"scroll":        {required: []string{"x", "y"}, new: func() validator { return &ScrollEvent{} }},
+	"mouseRelative": {required: []string{"action"}, new: func() validator { return &RelativeMouseEvent{} }},
+	"touch":         {required: []string{"action", "touches"}, new: func() validator { return &TouchEvent{} }},
+	"keyboard":      {required: []string{"key", "action"}, new: func() validator { return &KeyboardEvent{} }},
+	"text":          {required: []string{"text"}, new: func() validator { return &TextEvent{} }},
+	"typeText":      {required: []string{"action"}, new: func() validator { return &TypeTextEvent{} }},
+	"clipboard":     {required: []string{"action"}, new: func() validator { return &ClipboardEvent{} }},
+	"upload":        {required: []string{"action", "id"}, new: func() validator { return &UploadEvent{} }},
+	"control":       {required: []string{"enabled"}, new: func() validator { return &ControlStateEvent{} }},
+	"release":       {new: func() validator { return &ReleaseEvent{} }},
+}
+
+// decodeControlEvent décode et valide data selon le type du message. Toute
+// erreur renvoyée est une *protocolError.
+func decodeControlEvent(event ControlEvent) (validator, error) {
+	schema, ok := eventSchemas[event.Type]
+	if !ok {
+		return nil, &protocolError{Code: errUnknownEvent, Message: fmt.Sprintf("type d'événement inconnu: %q", event.Type)}
+	}
+
+	v := schema.new()
+	if len(schema.required) > 0 {
+		var fields map[string]json.RawMessage
+		if len(event.Data) == 0 || json.Unmarshal(event.Data, &fields) != nil || fields == nil {
+			return nil, invalidEvent("data doit être un objet")
+		}
+		for _, name := range schema.required {
+			if raw, ok := fields[name]; !ok || string(raw) == "null" {
+				return nil, invalidEvent("champ %q manquant", name)
+			}
+		}
+		if err := json.Unmarshal(event.Data, v); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				return nil, invalidEvent("champ %q: %s attendu", typeErr.Field, typeErr.Type)
+			}
+			return nil, invalidEvent("data invalide: %v", err)
+		}
+	}
+
+	if err := v.validate(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func oneOf(field, value string, allowed ...string) error {
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return invalidEvent("champ %q: valeur %q invalide (attendu: %s)", field, value, strings.Join(allowed, ", "))
+}
+
+func (e *HelloEvent) validate() error {
+	if e.Version < legacyProtocolVersion {
+		return invalidEvent("version de protocole invalide: %d", e.Version)
+	}
+	return nil
+}
+
+func (e *RefreshEvent) validate() error      { return nil }
+func (e *KeyframeEvent) validate() error     { return nil }
+func (e *ControlStateEvent) validate() error { return nil }
+func (e *ReleaseEvent) validate() error      { return nil }
+
+func (e *CodecEvent) validate() error {
+	return oneOf("codec", e.Codec, supportedCodecs...)
+}
+
+func (e *ScreenEvent) validate() error {
+	if e.Screen < -1 {
+		return invalidEvent("écran invalide: %d", e.Screen)
+	}
+	return nil
+}
+
+func (e *FPSEvent) validate() error {
+	if e.FPS <= 0 || e.FPS > 120 {
+		return invalidEvent("FPS hors limites: %d (1-120)", e.FPS)
+	}
+	return nil
+}
+
+func (e *QualityEvent) validate() error {
+	if e.Quality < 0 || e.Quality > 100 {
+		return invalidEvent("qualité hors limites: %d (0-100)", e.Quality)
+	}
+	return nil
+}
+
+func (e *MouseEvent) validate() error {
+	if e.X < 0 || e.Y < 0 || e.X > 65535 || e.Y > 65535 {
+		return invalidEvent("coordonnées hors limites: %d,%d", e.X, e.Y)
+	}
+	if err := oneOf("action", e.Action, "move", "drag", "down", "up", "scroll"); err != nil {
+		return err
+	}
+	switch e.Action {
+	case "down", "up", "drag":
+		return oneOf("button", e.Button, "left", "right", "middle")
+	case "scroll":
+		if e.Scroll != 1 && e.Scroll != -1 {
+			return invalidEvent("champ \"scroll\": 1 ou -1 attendu")
+		}
+	}
+	return nil
+}
+
+// maxScrollDelta écarte les valeurs absurdes avant conversion en crans.
+const maxScrollDelta = 100000
+
+func (e *ScrollEvent) validate() error {
+	if e.X < 0 || e.Y < 0 || e.X > 65535 || e.Y > 65535 {
+		return invalidEvent("coordonnées hors limites: %d,%d", e.X, e.Y)
+	}
+	if e.DeltaMode < 0 || e.DeltaMode > 2 {
+		return invalidEvent("deltaMode invalide: %d (0, 1 ou 2)", e.DeltaMode)
+	}
+	if math.Abs(e.DeltaX) > maxScrollDelta || math.Abs(e.DeltaY) > maxScrollDelta {
+		return invalidEvent("delta de défilement hors limites")
+	}
+	return nil
+}
+
+// maxRelativeDelta borne un déplacement relatif unique.
+const maxRelativeDelta = 10000
+
+func (e *RelativeMouseEvent) validate() error {
+	if err := oneOf("action", e.Action, "move", "down", "up"); err != nil {
+		return err
+	}
+	if e.Action != "move" {
+		return oneOf("button", e.Button, "left", "right", "middle")
+	}
+	if e.DX < -maxRelativeDelta || e.DX > maxRelativeDelta || e.DY < -maxRelativeDelta || e.DY > maxRelativeDelta {
+		return invalidEvent("déplacement relatif hors limites: %d,%d", e.DX, e.DY)
+	}
+	return nil
+}
+
+func (e *TouchEvent) validate() error {
+	if err := oneOf("action", e.Action, "start", "move", "end", "cancel"); err != nil {
+		return err
+	}
+	if len(e.Touches) > maxTouchContacts {
+		return invalidEvent("trop de contacts: %d (max %d)", len(e.Touches), maxTouchContacts)
+	}
+	for _, t := range e.Touches {
+		if t.X < 0 || t.Y < 0 || t.X > 65535 || t.Y > 65535 {
+			return invalidEvent("coordonnées hors limites: %d,%d", t.X, t.Y)
+		}
+	}
+	return nil
+}
+
+func (e *KeyboardEvent) validate() error {
+	if e.Key == "" || len(e.Key) > 32 {
+		return invalidEvent("champ \"key\" invalide")
+	}
+	if len(e.Code) > 32 {
+		return invalidEvent("champ \"code\" invalide")
+	}
+	return oneOf("action", e.Action, "down", "up", "press")
+}
+
+// maxTextLength borne un message "text" : une frappe ou une composition IME.
+const maxTextLength = 256
+
+func (e *TextEvent) validate() error {
+	if e.Text == "" || !utf8.ValidString(e.Text) || utf8.RuneCountInString(e.Text) > maxTextLength {
+		return invalidEvent("champ \"text\" invalide (1-%d caractères UTF-8)", maxTextLength)
+	}
+	return nil
+}
+
+func (e *TypeTextEvent) validate() error {
+	if err := oneOf("action", e.Action, "start", "cancel"); err != nil {
+		return err
+	}
+	if e.Action == "cancel" {
+		return nil
+	}
+	if e.Text == "" || !utf8.ValidString(e.Text) || utf8.RuneCountInString(e.Text) > maxTypeTextLength {
+		return invalidEvent("champ \"text\" invalide (1-%d caractères UTF-8)", maxTypeTextLength)
+	}
+	if e.Delay < 0 || e.Delay > maxTypeDelay {
+		return invalidEvent("délai hors limites: %d (0-%d ms)", e.Delay, maxTypeDelay)
+	}
+	return nil
+}
+
+func (e *ClipboardEvent) validate() error {
+	if err := oneOf("action", e.Action, "get", "set", "watch", "unwatch", "history", "apply"); err != nil {
+		return err
+	}
+	if e.Selection != "" {
+		if err := oneOf("selection", e.Selection, selectionClipboard, selectionPrimary); err != nil {
+			return err
+		}
+	}
+	if e.Action == "apply" && e.ID == 0 {
+		return invalidEvent("champ \"id\" requis pour \"apply\"")
+	}
+	if e.Action != "set" || e.Mime == "" || e.Mime == mimeText {
+		return nil
+	}
+	if err := oneOf("mime", e.Mime, clipboardMimeTypes...); err != nil {
+		return err
+	}
+	if e.Data == "" {
+		return invalidEvent("champ \"data\" requis pour %s", e.Mime)
+	}
+	if base64.StdEncoding.DecodedLen(len(e.Data)) > maxClipboardSize {
+		return invalidEvent("champ \"data\" trop volumineux (max %d octets)", maxClipboardSize)
+	}
+	if _, err := base64.StdEncoding.DecodeString(e.Data); err != nil {
+		return invalidEvent("champ \"data\": base64 invalide")
+	}
+	return nil
+}
+
+func (e *ClipboardEvent) selection() string {
+	if e.Selection == "" {
+		return selectionClipboard
+	}
+	return e.Selection
+}
+
+// content renvoie le contenu d'un "set" déjà validé.
+func (e *ClipboardEvent) content() clipboardContent {
+	if e.Mime == "" || e.Mime == mimeText {
+		return clipboardContent{mime: mimeText, data: []byte(e.Text), text: e.Text}
+	}
+	data, _ := base64.StdEncoding.DecodeString(e.Data)
+	return clipboardContent{mime: e.Mime, data: data, text: e.Text}
+}
+
+func (e *UploadEvent) validate() error {
+	if err := oneOf("action", e.Action, "start", "chunk", "cancel"); err != nil {
+		return err
+	}
+	if !uploadIDPattern.MatchString(e.ID) {
+		return invalidEvent("champ \"id\" invalide (8-64 caractères A-Z, a-z, 0-9, _ ou -)")
+	}
+	switch e.Action {
+	case "start":
+		if e.Name == "" || len(e.Name) > 1024 {
+			return invalidEvent("champ \"name\" invalide")
+		}
+		if e.Size < 0 {
+			return invalidEvent("taille invalide: %d", e.Size)
+		}
+	case "chunk":
+		if e.Offset < 0 {
+			return invalidEvent("offset invalide: %d", e.Offset)
+		}
+		if e.Data == "" || base64.StdEncoding.DecodedLen(len(e.Data)) > uploadChunkSize {
+			return invalidEvent("champ \"data\" invalide (1 à %d octets)", uploadChunkSize)
+		}
+		if _, err := base64.StdEncoding.DecodeString(e.Data); err != nil {
+			return invalidEvent("champ \"data\": base64 invalide")
+		}
+	}
+	return nil
+}
+
+func newControlEvent(eventType string, data interface{}) (ControlEvent, error) {
+	if data == nil {
+		return ControlEvent{Type: eventType}, nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return ControlEvent{}, err
+	}
+	return ControlEvent{Type: eventType, Data: raw}, nil
+}
+
+// parseLegacyCommand traduit une commande texte du protocole v1 en message
+// typé. Ces commandes restent acceptées pendant la période de dépréciation.
+func parseLegacyCommand(command string) (ControlEvent, bool) {
+	var data interface{}
+	var eventType string
+
+	switch {
+	case command == "refresh":
+		eventType = "refresh"
+	case command == "keyframe":
+		eventType = "keyframe"
+	case strings.HasPrefix(command, "codec:"):
+		eventType, data = "codec", CodecEvent{Codec: strings.TrimPrefix(command, "codec:")}
+	case strings.HasPrefix(command, "screen:"):
+		screenStr := strings.TrimPrefix(command, "screen:")
+		screenIndex := -1
+		if screenStr != "all" {
+			if n, _ := fmt.Sscanf(screenStr, "%d", &screenIndex); n != 1 {
+				return ControlEvent{}, false
+			}
+		}
+		eventType, data = "screen", ScreenEvent{Screen: screenIndex}
+	case strings.HasPrefix(command, "fps:"):
+		var fps int
+		if n, _ := fmt.Sscanf(strings.TrimPrefix(command, "fps:"), "%d", &fps); n != 1 {
+			return ControlEvent{}, false
+		}
+		eventType, data = "fps", FPSEvent{FPS: fps}
+	case strings.HasPrefix(command, "quality:"):
+		qualityStr := strings.TrimPrefix(command, "quality:")
+		quality := 0
+		if qualityStr != "auto" {
+			if n, _ := fmt.Sscanf(qualityStr, "%d", &quality); n != 1 {
+				return ControlEvent{}, false
+			}
+		}
+		eventType, data = "quality", QualityEvent{Quality: quality}
+	default:
+		return ControlEvent{}, false
+	}
+
+	event, err := newControlEvent(eventType, data)
+	return event, err == nil
+}
+
+func inputAvailable() bool {
+	switch runtime.GOOS {
+	case "linux":
+		return linuxInput != nil
+	case "windows":
+		_, err := exec.LookPath("powershell")
+		return err == nil
+	case "darwin":
+		_, err := exec.LookPath("osascript")
+		return err == nil
+	}
+	return false
+}
+
+// textAvailable indique si les messages "text" sont pris en charge. Sous
+// Linux, uinput émule un clavier physique et ne peut pas saisir de texte
+// indépendamment de la disposition : le navigateur envoie alors des touches.
+func textAvailable() bool {
+	if runtime.GOOS == "linux" {
+		_, ok := linuxInput.(textInput)
+		return ok
+	}
+	return inputAvailable()
+}
+
+func clipboardAvailable() bool {
+	switch runtime.GOOS {
+	case "linux":
+		_, err := exec.LookPath("xclip")
+		return err == nil
+	case "windows":
+		_, err := exec.LookPath("powershell")
+		return err == nil
+	case "darwin":
+		_, err := exec.LookPath("pbpaste")
+		return err == nil
+	}
+	return false
+}
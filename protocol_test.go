@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// decodeMessage reprend le traitement d'un message texte par handleWebSocket :
+// JSON du protocole v2, sinon commande texte du protocole v1.
+func decodeMessage(message string) (validator, error) {
+	command := strings.TrimSpace(message)
+	var event ControlEvent
+	if strings.HasPrefix(command, "{") {
+		if err := json.Unmarshal([]byte(message), &event); err != nil {
+			return nil, &protocolError{Code: errInvalidJSON, Message: err.Error()}
+		}
+	} else {
+		legacy, ok := parseLegacyCommand(command)
+		if !ok {
+			return nil, &protocolError{Code: errUnknownCommand, Message: command}
+		}
+		event = legacy
+	}
+	return decodeControlEvent(event)
+}
+
+func FuzzDecodeControlEvent(f *testing.F) {
+	seeds := []string{
+		// Protocole v1
+		"refresh",
+		"keyframe",
+		"codec:tiles",
+		"screen:all",
+		"screen:1",
+		"screen:-5",
+		"fps:30",
+		"fps:abc",
+		"quality:auto",
+		"quality:90",
+		// Protocole v2
+		`{"type":"hello","data":{"version":2,"codecs":["tiles","jpeg"]}}`,
+		`{"type":"refresh"}`,
+		`{"type":"screen","data":{"screen":-1}}`,
+		`{"type":"fps","data":{"fps":121}}`,
+		`{"type":"quality","data":{"quality":0}}`,
+		`{"type":"codec","data":{"codec":"png"}}`,
+		`{"type":"mouse","data":{"x":10,"y":20,"button":"left","action":"down"}}`,
+		`{"type":"mouse","data":{"x":10,"y":20,"action":"scroll","scroll":1}}`,
+		`{"type":"scroll","data":{"x":1,"y":2,"deltaX":0,"deltaY":-120,"deltaMode":0}}`,
+		`{"type":"mouseRelative","data":{"dx":5,"dy":-3,"action":"move"}}`,
+		`{"type":"touch","data":{"action":"move","touches":[{"id":0,"x":100,"y":200}]}}`,
+		`{"type":"keyboard","data":{"key":"a","code":"KeyA","action":"down","ctrl":true}}`,
+		`{"type":"text","data":{"text":"é"}}`,
+		`{"type":"typeText","data":{"action":"start","text":"bonjour","delay":30}}`,
+		`{"type":"clipboard","data":{"action":"set","text":"x"}}`,
+		`{"type":"clipboard","data":{"action":"set","mime":"image/png","data":"iVBORw0KGgo="}}`,
+		`{"type":"clipboard","data":{"action":"apply","id":3,"selection":"primary"}}`,
+		`{"type":"upload","data":{"action":"start","id":"abcdefgh","name":"a.txt","size":3}}`,
+		`{"type":"upload","data":{"action":"chunk","id":"abcdefgh","offset":0,"data":"YWJj"}}`,
+		`{"type":"control","data":{"enabled":false}}`,
+		`{"type":"release"}`,
+		// Messages invalides
+		`{"type":"mouse","data":null}`,
+		`{"type":"mouse","data":{"x":"10","y":20,"action":"move"}}`,
+		`{"type":"inconnu"}`,
+		`{"type":"keyboard","data":[1,2]}`,
+		`{`,
+		"",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, message string) {
+		v, err := decodeMessage(message)
+		if err != nil {
+			var perr *protocolError
+			if !errors.As(err, &perr) || perr.Code == "" {
+				t.Fatalf("%q: erreur sans code de protocole: %v", message, err)
+			}
+			return
+		}
+		if v == nil {
+			t.Fatalf("%q: ni événement ni erreur", message)
+		}
+		if err := v.validate(); err != nil {
+			t.Fatalf("%q: événement accepté puis refusé: %v", message, err)
+		}
+	})
+}
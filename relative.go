@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// relativePointer est implémenté par les backends capables d'injecter des
+// mouvements relatifs (mode pointer lock) : button et action suivent
+// MouseEvent, sans position absolue.
+type relativePointer interface {
+	RelativeMouse(dx, dy int, button, action string) error
+}
+
+func simulateRelativeMouse(dx, dy int, button, action string) error {
+	switch runtime.GOOS {
+	case "windows":
+		return simulateRelativeMouseWindows(dx, dy, button, action)
+	case "linux":
+		backend, ok := linuxInput.(relativePointer)
+		if !ok {
+			return fmt.Errorf("mouvements relatifs non supportés par le backend d'entrée")
+		}
+		return backend.RelativeMouse(dx, dy, button, action)
+	default:
+		return fmt.Errorf("mouvements relatifs non supportés sur %s", runtime.GOOS)
+	}
+}
+
+func simulateRelativeMouseWindows(dx, dy int, button, action string) error {
+	// mouse_event : MOUSEEVENTF_MOVE sans MOUSEEVENTF_ABSOLUTE = déplacement relatif
+	flags := 0x0001
+	switch action {
+	case "down", "up":
+		down, up := 0x0002, 0x0004
+		if button == "right" {
+			down, up = 0x0008, 0x0010
+		} else if button == "middle" {
+			down, up = 0x0020, 0x0040
+		}
+		flags = down
+		if action == "up" {
+			flags = up
+		}
+		dx, dy = 0, 0
+	}
+
+	psScript := fmt.Sprintf(`
+	Add-Type -Namespace Win32 -Name Mouse -MemberDefinition '[DllImport("user32.dll")] public static extern void mouse_event(uint flags, int dx, int dy, int data, System.UIntPtr extra);'
+	[Win32.Mouse]::mouse_event(%d, %d, %d, 0, [System.UIntPtr]::Zero)
+	`, flags, dx, dy)
+
+	cmd := exec.Command("powershell", "-WindowStyle", "Hidden", "-ExecutionPolicy", "Bypass", "-Command", psScript)
+	return cmd.Run()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// Les deltas de défilement sont convertis en 1/120 de cran, l'unité de
+// REL_WHEEL_HI_RES sous Linux et de WHEEL_DELTA sous Windows.
+const (
+	scrollUnitsPerNotch  = 120
+	scrollPixelsPerNotch = 100 // deltaMode 0 : un cran de molette ≈ 100 px
+	scrollLinesPerNotch  = 3   // deltaMode 1
+	scrollNotchesPerPage = 10  // deltaMode 2
+	maxScrollNotches     = 15  // par événement, un geste rapide reste borné
+)
+
+// units convertit les deltas du navigateur en 1/120 de cran (dy > 0 : vers
+// le bas, dx > 0 : vers la droite).
+func (e *ScrollEvent) units() (dx, dy int) {
+	convert := func(delta float64) int {
+		var units float64
+		switch e.DeltaMode {
+		case 1:
+			units = delta * scrollUnitsPerNotch / scrollLinesPerNotch
+		case 2:
+			units = delta * scrollUnitsPerNotch * scrollNotchesPerPage
+		default:
+			units = delta * scrollUnitsPerNotch / scrollPixelsPerNotch
+		}
+		limit := float64(maxScrollNotches * scrollUnitsPerNotch)
+		return int(max(-limit, min(limit, units)))
+	}
+	return convert(e.DeltaX), convert(e.DeltaY)
+}
+
+// scrollAccumulator cumule les petits deltas (pavé tactile) jusqu'à former
+// des crans entiers pour les backends qui ne savent cliquer que 4/5/6/7.
+type scrollAccumulator struct {
+	x, y int
+}
+
+func accumulateAxis(acc *int, delta int) int {
+	// Changement de sens : le reliquat précédent est abandonné
+	if (*acc > 0 && delta < 0) || (*acc < 0 && delta > 0) {
+		*acc = 0
+	}
+	*acc += delta
+	notches := *acc / scrollUnitsPerNotch
+	*acc -= notches * scrollUnitsPerNotch
+	return notches
+}
+
+func (a *scrollAccumulator) add(dx, dy int) (notchesX, notchesY int) {
+	return accumulateAxis(&a.x, dx), accumulateAxis(&a.y, dy)
+}
+
+// simulateScroll défile de dx, dy (en 1/120 de cran) à la position x, y.
+// Le défilement fin est utilisé quand le backend le permet, sinon acc
+// regroupe les deltas en crans.
+func simulateScroll(x, y, dx, dy int, acc *scrollAccumulator) error {
+	switch runtime.GOOS {
+	case "windows":
+		return simulateScrollWindows(x, y, dx, dy)
+	case "linux":
+		if linuxInput == nil {
+			return fmt.Errorf("aucun backend d'entrée Linux disponible")
+		}
+		if smooth, ok := linuxInput.(smoothScroller); ok {
+			return smooth.SmoothScroll(x, y, dx, dy)
+		}
+		notchesX, notchesY := acc.add(dx, dy)
+		if notchesX == 0 && notchesY == 0 {
+			return nil
+		}
+		return linuxInput.Scroll(x, y, notchesX, notchesY)
+	case "darwin":
+		_, notchesY := acc.add(dx, dy)
+		return simulateScrollMacOS(x, y, notchesY)
+	default:
+		return fmt.Errorf("OS non supporté: %s", runtime.GOOS)
+	}
+}
+
+func simulateScrollWindows(x, y, dx, dy int) error {
+	// mouse_event : MOUSEEVENTF_WHEEL (positif = haut), MOUSEEVENTF_HWHEEL
+	// (positif = droite), en multiples libres de WHEEL_DELTA (120)
+	psScript := fmt.Sprintf(`
+	Add-Type -AssemblyName System.Windows.Forms
+	Add-Type -AssemblyName System.Drawing
+	Add-Type -Namespace Win32 -Name Mouse -MemberDefinition '[DllImport("user32.dll")] public static extern void mouse_event(uint flags, int dx, int dy, int data, System.UIntPtr extra);'
+	[System.Windows.Forms.Cursor]::Position = New-Object System.Drawing.Point(%d, %d)
+	if (%d -ne 0) { [Win32.Mouse]::mouse_event(0x0800, 0, 0, %d, [System.UIntPtr]::Zero) }
+	if (%d -ne 0) { [Win32.Mouse]::mouse_event(0x1000, 0, 0, %d, [System.UIntPtr]::Zero) }
+	`, x, y, dy, -dy, dx, dx)
+
+	cmd := exec.Command("powershell", "-WindowStyle", "Hidden", "-ExecutionPolicy", "Bypass", "-Command", psScript)
+	return cmd.Run()
+}
+
+func simulateScrollMacOS(x, y, notches int) error {
+	action := "scroll:-1"
+	if notches < 0 {
+		action, notches = "scroll:1", -notches
+	}
+	for i := 0; i < notches; i++ {
+		if err := simulateMouseMacOS(x, y, "wheel", action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
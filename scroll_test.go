@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScrollEventUnits(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  ScrollEvent
+		dx, dy int
+	}{
+		{"pixels, un cran", ScrollEvent{DeltaY: 100}, 0, 120},
+		{"pixels, demi-cran vers le haut", ScrollEvent{DeltaY: -50}, 0, -60},
+		{"pixels, pavé tactile", ScrollEvent{DeltaX: 1.5, DeltaY: 4}, 1, 4},
+		{"pixels, horizontal", ScrollEvent{DeltaX: -200}, -240, 0},
+		{"lignes, une ligne", ScrollEvent{DeltaY: 1, DeltaMode: 1}, 0, 40},
+		{"lignes, un cran", ScrollEvent{DeltaX: 3, DeltaY: -3, DeltaMode: 1}, 120, -120},
+		{"pages, une page", ScrollEvent{DeltaY: 1, DeltaMode: 2}, 0, 1200},
+		{"pages, demi-page à gauche", ScrollEvent{DeltaX: -0.5, DeltaMode: 2}, -600, 0},
+		{"pixels, borné", ScrollEvent{DeltaY: 5000}, 0, maxScrollNotches * scrollUnitsPerNotch},
+		{"lignes, borné", ScrollEvent{DeltaY: -100, DeltaMode: 1}, 0, -maxScrollNotches * scrollUnitsPerNotch},
+		{"pages, borné", ScrollEvent{DeltaX: 3, DeltaY: -2, DeltaMode: 2}, maxScrollNotches * scrollUnitsPerNotch, -maxScrollNotches * scrollUnitsPerNotch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dx, dy := tt.event.units()
+			if dx != tt.dx || dy != tt.dy {
+				t.Errorf("units() = %d, %d ; attendu %d, %d", dx, dy, tt.dx, tt.dy)
+			}
+		})
+	}
+}
+
+func TestScrollAccumulator(t *testing.T) {
+	type step struct {
+		dx, dy             int
+		notchesX, notchesY int
+	}
+	tests := []struct {
+		name   string
+		steps  []step
+		remain scrollAccumulator
+	}{
+		{"cran entier", []step{{0, 120, 0, 1}}, scrollAccumulator{}},
+		{"petits deltas cumulés", []step{{0, 40, 0, 0}, {0, 40, 0, 0}, {0, 50, 0, 1}}, scrollAccumulator{y: 10}},
+		{"plusieurs crans et reliquat", []step{{250, -250, 2, -2}}, scrollAccumulator{x: 10, y: -10}},
+		{"reliquat reporté", []step{{0, 100, 0, 0}, {0, 100, 0, 1}, {0, 100, 0, 1}}, scrollAccumulator{y: 60}},
+		{"changement de sens", []step{{0, 100, 0, 0}, {0, -40, 0, 0}, {0, -90, 0, -1}}, scrollAccumulator{y: -10}},
+		{"axes indépendants", []step{{60, 0, 0, 0}, {0, -60, 0, 0}, {60, -60, 1, -1}}, scrollAccumulator{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var acc scrollAccumulator
+			for i, s := range tt.steps {
+				nx, ny := acc.add(s.dx, s.dy)
+				if nx != s.notchesX || ny != s.notchesY {
+					t.Errorf("étape %d: %d, %d crans ; attendu %d, %d", i, nx, ny, s.notchesX, s.notchesY)
+				}
+			}
+			if acc != tt.remain {
+				t.Errorf("reliquat %+v, attendu %+v", acc, tt.remain)
+			}
+		})
+	}
+}
+
+// Sans défilement fin, le backend ne reçoit que des crans entiers.
+func TestSimulateScrollNotches(t *testing.T) {
+	backend := &recordedInput{}
+	useInput(t, backend)
+
+	var acc scrollAccumulator
+	for _, dy := range []int{50, 50, 50, -30} {
+		if err := simulateScroll(10, 20, 0, dy, &acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "scroll 10,20 0,1"
+	if got := strings.Join(backend.take(), "; "); got != want {
+		t.Errorf("injecté %q, attendu %q", got, want)
+	}
+}
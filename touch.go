@@ -0,0 +1,210 @@
+package main
+
+import (
+	"image"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	maxTouchContacts = 10
+	touchSlop        = 10 // px parcourus avant qu'un appui devienne un glisser
+	longPressDelay   = 600 * time.Millisecond
+)
+
+// touchContact est un doigt posé, en coordonnées écran déjà ajustées.
+type touchContact struct {
+	ID int
+	X  int
+	Y  int
+}
+
+// touchInput est implémenté par les backends capables d'injecter de vrais
+// contacts multi-touch. contacts est l'ensemble complet des doigts posés par
+// owner (un client) : ceux qui n'y figurent plus sont levés.
+type touchInput interface {
+	Touch(owner uint64, contacts []touchContact) error
+}
+
+// Étapes de l'émulation souris des gestes tactiles
+const (
+	touchIdle        = iota
+	touchPending     // un doigt posé, ni déplacé ni appui long : tap possible
+	touchDrag        // un doigt déplacé : glisser bouton gauche
+	touchLongPressed // appui long : clic droit déjà envoyé
+	touchScroll      // deux doigts : défilement jusqu'à ce que tous soient levés
+)
+
+// touchGesture traduit les contacts d'un client en souris pour les backends
+// sans multi-touch : tap = clic, glisser = glisser gauche, appui long = clic
+// droit, glisser à deux doigts = défilement.
+type touchGesture struct {
+	mu       sync.Mutex
+	state    int
+	start    image.Point
+	last     image.Point
+	centroid image.Point
+	timer    *time.Timer
+	press    int // numéro de l'appui en cours, pour ignorer un appui long périmé
+	scroll   scrollAccumulator
+}
+
+// touchLongPress est mis en file d'entrée par le timer d'appui long, pour
+// que le clic droit soit injecté dans l'ordre des autres entrées.
+type touchLongPress struct {
+	press int
+}
+
+func (*touchLongPress) validate() error { return nil }
+
+func centroidOf(contacts []touchContact) image.Point {
+	var sum image.Point
+	for _, t := range contacts {
+		sum = sum.Add(image.Pt(t.X, t.Y))
+	}
+	return sum.Div(len(contacts))
+}
+
+func (s *ScreenStreamer) handleTouch(c *client, ev *TouchEvent) error {
+	screenIndex := int(c.screen.Load())
+	contacts := make([]touchContact, 0, len(ev.Touches))
+	if ev.Action != "cancel" {
+		for _, t := range ev.Touches {
+			x, y := s.adjustMouseCoordinates(screenIndex, t.X, t.Y)
+			contacts = append(contacts, touchContact{ID: t.ID, X: x, Y: y})
+		}
+	}
+
+	if runtime.GOOS == "linux" {
+		if backend, ok := linuxInput.(touchInput); ok {
+			return backend.Touch(c.id, contacts)
+		}
+	}
+	return c.touch.update(c, contacts, ev.Action == "cancel")
+}
+
+func (g *touchGesture) update(c *client, contacts []touchContact, cancel bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(contacts) == 0 {
+		g.stopTimer()
+		state := g.state
+		g.state = touchIdle
+		switch {
+		case state == touchDrag:
+			return touchMouse(c, g.last, "left", "up")
+		case state == touchPending && !cancel:
+			if err := touchMouse(c, g.start, "left", "down"); err != nil {
+				return err
+			}
+			return touchMouse(c, g.start, "left", "up")
+		}
+		return nil
+	}
+
+	if len(contacts) >= 2 {
+		center := centroidOf(contacts)
+		if g.state != touchScroll {
+			g.stopTimer()
+			if g.state == touchDrag {
+				if err := touchMouse(c, g.last, "left", "up"); err != nil {
+					return err
+				}
+			}
+			g.state, g.centroid, g.scroll = touchScroll, center, scrollAccumulator{}
+			return nil
+		}
+		// Défilement naturel : le contenu suit les doigts
+		delta := center.Sub(g.centroid)
+		g.centroid = center
+		scroll := ScrollEvent{DeltaX: float64(-delta.X), DeltaY: float64(-delta.Y)}
+		dx, dy := scroll.units()
+		if dx == 0 && dy == 0 {
+			return nil
+		}
+		return simulateScroll(center.X, center.Y, dx, dy, &g.scroll)
+	}
+
+	pos := image.Pt(contacts[0].X, contacts[0].Y)
+	g.last = pos
+	switch g.state {
+	case touchIdle:
+		g.state, g.start = touchPending, pos
+		g.press++
+		press := g.press
+		g.timer = time.AfterFunc(longPressDelay, func() {
+			c.input.push(queuedInput{eventType: "longPress", event: &touchLongPress{press: press}}, true)
+		})
+		return touchMouse(c, pos, "none", "move")
+	case touchPending:
+		if d := pos.Sub(g.start); d.X*d.X+d.Y*d.Y <= touchSlop*touchSlop {
+			return nil
+		}
+		g.stopTimer()
+		g.state = touchDrag
+		if err := touchMouse(c, g.start, "left", "down"); err != nil {
+			return err
+		}
+		return touchMouse(c, pos, "left", "drag")
+	case touchDrag:
+		return touchMouse(c, pos, "left", "drag")
+	}
+	// touchLongPressed, touchScroll avec un seul doigt restant : ignoré
+	return nil
+}
+
+func (g *touchGesture) stopTimer() {
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+}
+
+func (g *touchGesture) longPress(c *client, press int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.state != touchPending || g.press != press {
+		return
+	}
+	g.timer = nil
+	g.state = touchLongPressed
+
+	err := touchMouse(c, g.start, "right", "down")
+	if err == nil {
+		err = touchMouse(c, g.start, "right", "up")
+	}
+	if err != nil {
+		log.Printf("Erreur appui long: %v", err)
+	}
+	c.reportResult(serviceInput, "touch", err)
+}
+
+// touchMouse injecte un événement souris émulé, suivi comme un vrai appui
+// pour être relâché si le client disparaît en plein glisser.
+func touchMouse(c *client, pos image.Point, button, action string) error {
+	err := simulateMouseClick(pos.X, pos.Y, button, action)
+	if err == nil || action == "up" {
+		c.pressed.trackButton(pos.X, pos.Y, button, action)
+	}
+	return err
+}
+
+// releaseTouch lève les doigts encore posés par le client et abandonne le
+// geste en cours ; un glisser émulé est relâché par pressedInputs.
+func releaseTouch(c *client) {
+	if runtime.GOOS == "linux" {
+		if backend, ok := linuxInput.(touchInput); ok {
+			if err := backend.Touch(c.id, nil); err != nil {
+				log.Printf("Erreur levée des contacts: %v", err)
+			}
+		}
+	}
+
+	c.touch.mu.Lock()
+	c.touch.stopTimer()
+	c.touch.state = touchIdle
+	c.touch.mu.Unlock()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTouchGesture(t *testing.T) {
+	type step struct {
+		contacts  []touchContact
+		cancel    bool
+		longPress string // "current" ou "stale" : appui long déclenché au lieu des contacts
+		want      []string
+	}
+	at := func(x, y int) []touchContact { return []touchContact{{ID: 1, X: x, Y: y}} }
+	two := func(x1, y1, x2, y2 int) []touchContact {
+		return []touchContact{{ID: 1, X: x1, Y: y1}, {ID: 2, X: x2, Y: y2}}
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"tap", []step{
+			{contacts: at(10, 10), want: []string{"mouse 10,10 none move"}},
+			{want: []string{"mouse 10,10 left down", "mouse 10,10 left up"}},
+		}},
+		{"tap avec un léger tremblement", []step{
+			{contacts: at(10, 10), want: []string{"mouse 10,10 none move"}},
+			{contacts: at(16, 18)},
+			{want: []string{"mouse 10,10 left down", "mouse 10,10 left up"}},
+		}},
+		{"glisser au-delà de touchSlop", []step{
+			{contacts: at(10, 10), want: []string{"mouse 10,10 none move"}},
+			{contacts: at(10+touchSlop+1, 10), want: []string{"mouse 10,10 left down", "mouse 21,10 left drag"}},
+			{contacts: at(40, 12), want: []string{"mouse 40,12 left drag"}},
+			{want: []string{"mouse 40,12 left up"}},
+		}},
+		{"appui long", []step{
+			{contacts: at(10, 10), want: []string{"mouse 10,10 none move"}},
+			{longPress: "current", want: []string{"mouse 10,10 right down", "mouse 10,10 right up"}},
+			{contacts: at(60, 60)},
+			{},
+		}},
+		{"appui long périmé ignoré", []step{
+			{contacts: at(10, 10), want: []string{"mouse 10,10 none move"}},
+			{want: []string{"mouse 10,10 left down", "mouse 10,10 left up"}},
+			{contacts: at(50, 50), want: []string{"mouse 50,50 none move"}},
+			{longPress: "stale"},
+			{want: []string{"mouse 50,50 left down", "mouse 50,50 left up"}},
+		}},
+		{"appui long après un glisser ignoré", []step{
+			{contacts: at(10, 10), want: []string{"mouse 10,10 none move"}},
+			{contacts: at(40, 10), want: []string{"mouse 10,10 left down", "mouse 40,10 left drag"}},
+			{longPress: "current"},
+			{want: []string{"mouse 40,10 left up"}},
+		}},
+		{"défilement à deux doigts", []step{
+			{contacts: at(10, 200), want: []string{"mouse 10,200 none move"}},
+			{contacts: two(10, 200, 30, 200)},
+			{contacts: two(10, 100, 30, 100), want: []string{"scroll 20,100 0,1"}},
+			{contacts: two(10, 150, 30, 150)},
+			{contacts: two(10, 200, 30, 200), want: []string{"scroll 20,200 0,-1"}},
+			{contacts: at(10, 200)},
+			{},
+		}},
+		{"deuxième doigt pendant un glisser", []step{
+			{contacts: at(10, 10), want: []string{"mouse 10,10 none move"}},
+			{contacts: at(40, 10), want: []string{"mouse 10,10 left down", "mouse 40,10 left drag"}},
+			{contacts: two(40, 10, 60, 10), want: []string{"mouse 40,10 left up"}},
+			{},
+		}},
+		{"annulation avant le tap", []step{
+			{contacts: at(10, 10), want: []string{"mouse 10,10 none move"}},
+			{cancel: true},
+		}},
+		{"annulation pendant un glisser", []step{
+			{contacts: at(10, 10), want: []string{"mouse 10,10 none move"}},
+			{contacts: at(40, 10), want: []string{"mouse 10,10 left down", "mouse 40,10 left drag"}},
+			{cancel: true, want: []string{"mouse 40,10 left up"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &recordedInput{}
+			useInput(t, backend)
+			c := newClient(nil, 0)
+			g := &c.touch
+			t.Cleanup(func() { releaseTouch(c) })
+
+			for i, s := range tt.steps {
+				switch s.longPress {
+				case "current":
+					g.longPress(c, g.press)
+				case "stale":
+					g.longPress(c, g.press-1)
+				default:
+					if err := g.update(c, s.contacts, s.cancel); err != nil {
+						t.Fatalf("étape %d: %v", i, err)
+					}
+				}
+				if got, want := strings.Join(backend.take(), "; "), strings.Join(s.want, "; "); got != want {
+					t.Errorf("étape %d: injecté %q, attendu %q", i, got, want)
+				}
+			}
+			if g.state != touchIdle {
+				t.Errorf("état final %d, attendu touchIdle", g.state)
+			}
+			if keys, buttons := c.pressed.releaseAll(); len(keys)+len(buttons) != 0 {
+				t.Errorf("encore enfoncés: %v, %v", keys, buttons)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultTypeDelay     = 30 * time.Millisecond
+	maxTypeDelay         = 1000 // ms
+	maxTypeTextLength    = 20000
+	typeProgressInterval = 250 * time.Millisecond
+)
+
+// startTyping lance la saisie de text caractère par caractère. Une saisie
+// déjà en cours pour ce client est annulée au profit de la nouvelle.
+func (c *client) startTyping(text string, delay time.Duration) {
+	cancel := make(chan struct{})
+	c.typingMu.Lock()
+	if c.typingCancel != nil {
+		close(c.typingCancel)
+	}
+	c.typingCancel = cancel
+	c.typingMu.Unlock()
+
+	go c.typeText(text, delay, cancel)
+}
+
+// cancelTyping interrompt la saisie en cours ; sans effet s'il n'y en a pas.
+func (c *client) cancelTyping() {
+	c.typingMu.Lock()
+	if c.typingCancel != nil {
+		close(c.typingCancel)
+		c.typingCancel = nil
+	}
+	c.typingMu.Unlock()
+}
+
+func (c *client) finishTyping(cancel chan struct{}) {
+	c.typingMu.Lock()
+	if c.typingCancel == cancel {
+		c.typingCancel = nil
+	}
+	c.typingMu.Unlock()
+}
+
+func (c *client) typeText(text string, delay time.Duration, cancel chan struct{}) {
+	defer c.finishTyping(cancel)
+
+	total := utf8.RuneCountInString(text)
+	status := TypeTextStatus{State: "progress", Total: total}
+	c.sendEvent("typeText", status)
+	log.Printf("Client %d: saisie de %d caractère(s)", c.id, total)
+
+	useText := textAvailable()
+	lastProgress := time.Now()
+	for _, r := range text {
+		select {
+		case <-cancel:
+			status.State = "cancelled"
+			c.sendEvent("typeText", status)
+			return
+		case <-c.done:
+			return
+		default:
+		}
+
+		// Injecté par inputPump, dans l'ordre des autres entrées du client
+		in := &typedRune{r: r, useText: useText, result: make(chan error, 1)}
+		var err error
+		if !c.input.push(queuedInput{eventType: "typeText", event: in}, false) {
+			err = fmt.Errorf("file d'entrée pleine")
+		} else {
+			select {
+			case err = <-in.result:
+			case <-c.done:
+				return
+			}
+		}
+		if err != nil {
+			log.Printf("Erreur saisie typeText: %v", err)
+			c.reportResult(serviceInput, "typeText", err)
+			status.State, status.Message = "failed", err.Error()
+			c.sendEvent("typeText", status)
+			return
+		}
+		status.Typed++
+
+		if time.Since(lastProgress) >= typeProgressInterval {
+			lastProgress = time.Now()
+			c.sendEvent("typeText", status)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-cancel:
+		case <-c.done:
+		}
+	}
+
+	select {
+	case <-cancel:
+		status.State = "cancelled"
+		c.sendEvent("typeText", status)
+		return
+	case <-c.done:
+		return
+	default:
+	}
+	c.reportResult(serviceInput, "typeText", nil)
+	status.State = "done"
+	c.sendEvent("typeText", status)
+}
+
+// typedRune est un caractère d'une saisie typeText en file d'entrée ; le
+// résultat de l'injection est renvoyé sur result.
+type typedRune struct {
+	r       rune
+	useText bool
+	result  chan error
+}
+
+func (*typedRune) validate() error { return nil }
+
+// typeRune passe par les mêmes chemins que les messages "text" et
+// "keyboard" ; les fins de ligne et tabulations sont des touches.
+func typeRune(r rune, useText bool) error {
+	switch r {
+	case '\r':
+		return nil
+	case '\n':
+		return simulateKeyboard(&KeyboardEvent{Key: "Enter", Code: "Enter", Action: "press"})
+	case '\t':
+		return simulateKeyboard(&KeyboardEvent{Key: "Tab", Code: "Tab", Action: "press"})
+	}
+	if useText {
+		return simulateText(string(r))
+	}
+	ev := &KeyboardEvent{Key: string(r), Action: "press"}
+	if err := ev.validate(); err != nil {
+		return fmt.Errorf("caractère %q: %v", r, err)
+	}
+	return simulateKeyboard(ev)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTypeRune(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       rune
+		useText bool
+		want    string
+	}{
+		{"retour à la ligne", '\n', true, "key Enter/Enter press"},
+		{"retour à la ligne sans texte", '\n', false, "key Enter/Enter press"},
+		{"tabulation", '\t', true, "key Tab/Tab press"},
+		{"tabulation sans texte", '\t', false, "key Tab/Tab press"},
+		{"retour chariot ignoré", '\r', true, ""},
+		{"retour chariot ignoré sans texte", '\r', false, ""},
+		{"caractère par texte", 'é', true, "text é"},
+		{"caractère par touche", 'a', false, "key a/ press"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &recordedTextInput{}
+			useInput(t, backend)
+			if err := typeRune(tt.r, tt.useText); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(backend.take(), "; "); got != tt.want {
+				t.Errorf("injecté %q, attendu %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypeTextEventValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   TypeTextEvent
+		wantErr bool
+	}{
+		{"saisie", TypeTextEvent{Action: "start", Text: "bonjour"}, false},
+		{"annulation sans texte", TypeTextEvent{Action: "cancel"}, false},
+		{"action inconnue", TypeTextEvent{Action: "pause", Text: "a"}, true},
+		{"texte vide", TypeTextEvent{Action: "start"}, true},
+		{"UTF-8 invalide", TypeTextEvent{Action: "start", Text: "a\xffb"}, true},
+		{"longueur maximale", TypeTextEvent{Action: "start", Text: strings.Repeat("é", maxTypeTextLength)}, false},
+		{"trop long", TypeTextEvent{Action: "start", Text: strings.Repeat("é", maxTypeTextLength+1)}, true},
+		{"délai maximal", TypeTextEvent{Action: "start", Text: "a", Delay: maxTypeDelay}, false},
+		{"délai trop long", TypeTextEvent{Action: "start", Text: "a", Delay: maxTypeDelay + 1}, true},
+		{"délai négatif", TypeTextEvent{Action: "start", Text: "a", Delay: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.event.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, erreur attendue: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// typeTextStatus attend le prochain état de saisie envoyé à c.
+func typeTextStatus(t *testing.T, c *client) TypeTextStatus {
+	t.Helper()
+	for {
+		select {
+		case data := <-c.control:
+			var event ControlEvent
+			var status TypeTextStatus
+			if err := json.Unmarshal(data, &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Type != "typeText" {
+				continue
+			}
+			if err := json.Unmarshal(event.Data, &status); err != nil {
+				t.Fatal(err)
+			}
+			return status
+		case <-time.After(5 * time.Second):
+			t.Fatal("aucun état de saisie reçu")
+			return TypeTextStatus{}
+		}
+	}
+}
+
+// Une nouvelle saisie annule celle en cours : aucun caractère de l'ancienne
+// n'est injecté après le premier de la nouvelle.
+func TestStartTypingCancelsRunning(t *testing.T) {
+	backend := &recordedTextInput{}
+	useInput(t, backend)
+	_, c := testInputPump(t)
+
+	c.startTyping("aaaaaaaaaa", 50*time.Millisecond)
+	if status := typeTextStatus(t, c); status.State != "progress" || status.Total != 10 {
+		t.Fatalf("premier état %+v", status)
+	}
+	time.Sleep(120 * time.Millisecond)
+	c.startTyping("bb", time.Millisecond)
+
+	var first, second TypeTextStatus
+	for first.State != "cancelled" || second.State != "done" {
+		status := typeTextStatus(t, c)
+		switch {
+		case status.Total == 10:
+			first = status
+		case status.Total == 2:
+			second = status
+		}
+		if first.State == "done" {
+			t.Fatal("première saisie menée à son terme")
+		}
+	}
+	if first.Typed == 0 || first.Typed >= 10 {
+		t.Errorf("%d caractère(s) saisis avant l'annulation", first.Typed)
+	}
+	if second.Typed != 2 {
+		t.Errorf("%d caractère(s) de la nouvelle saisie, attendu 2", second.Typed)
+	}
+
+	events := backend.take()
+	want := make([]string, first.Typed)
+	for i := range want {
+		want[i] = "text a"
+	}
+	want = append(want, "text b", "text b")
+	if strings.Join(events, "; ") != strings.Join(want, "; ") {
+		t.Errorf("injecté %v, attendu %v", events, want)
+	}
+}
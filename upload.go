@@ -0,0 +1,557 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// Taille maximale d'un morceau envoyé par websocket, une fois décodé
+	uploadChunkSize = 1 << 20
+
+	// Un envoi interrompu peut être repris pendant ce délai, même après un
+	// redémarrage du serveur ; son fichier partiel est supprimé ensuite.
+	uploadExpiry = time.Hour
+
+	uploadProgressInterval = 250 * time.Millisecond
+
+	// Envois en cours, interrompus compris, au plus par session et en tout
+	uploadMaxPerSession = 8
+	uploadMaxPending    = 64
+
+	uploadPartPrefix = ".upload-"
+	uploadPartSuffix = ".part"
+)
+
+var uploadIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{8,64}$`)
+
+// errUploadOffset signale un morceau qui ne suit pas le dernier reçu : le
+// navigateur reprend à l'offset renvoyé.
+var errUploadOffset = errors.New("offset inattendu")
+
+// uploadManager écrit les fichiers envoyés par le navigateur dans
+// -upload-dir. Tous les accès passent par os.Root : aucun nom, lien
+// symbolique ou ".." ne peut en sortir.
+type uploadManager struct {
+	root    *os.Root
+	maxSize int64
+	// Octets réservés au plus par les envois en cours : taille annoncée, ou
+	// maxSize quand elle est inconnue
+	maxPending int64
+
+	// mu protège la liste et les réservations ; l'écriture d'un morceau ne
+	// prend que le verrou de son envoi. Ordre : mu puis pendingUpload.mu.
+	mu       sync.Mutex
+	uploads  map[string]*pendingUpload
+	reserved int64
+}
+
+// pendingUpload est un envoi en cours, écrit dans un fichier partiel caché
+// renommé à la fin.
+type pendingUpload struct {
+	id      string
+	session uint64 // client qui l'a ouvert ou repris en dernier
+	reserve int64
+
+	mu       sync.Mutex
+	name     string
+	size     int64 // -1 = inconnue jusqu'à la fin (multipart)
+	received int64
+	file     *os.File // nil quand la session est déconnectée, rouvert à la reprise
+	updated  time.Time
+	closed   bool // terminé, annulé ou expiré
+}
+
+func newUploadManager(dir string, maxSize, maxPending int64) (*uploadManager, error) {
+	if maxSize <= 0 || maxPending < maxSize {
+		return nil, fmt.Errorf("limites invalides: %d octets par fichier, %d en cours", maxSize, maxPending)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("création de %s: %v", dir, err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("ouverture de %s: %v", dir, err)
+	}
+	m := &uploadManager{root: root, maxSize: maxSize, maxPending: maxPending, uploads: make(map[string]*pendingUpload)}
+	m.mu.Lock()
+	m.expireLocked()
+	m.mu.Unlock()
+	return m, nil
+}
+
+func uploadPartName(id string) string {
+	return uploadPartPrefix + id + uploadPartSuffix
+}
+
+// cleanUploadName ne garde que le dernier élément du nom proposé par le
+// navigateur, sans caractères de contrôle.
+func cleanUploadName(name string) (string, error) {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "" || name == "." || name == ".." || name == "/" || len(name) > 255 || strings.HasPrefix(name, uploadPartPrefix) {
+		return "", fmt.Errorf("nom de fichier invalide: %q", name)
+	}
+	return name, nil
+}
+
+func (u *pendingUpload) status(state string) UploadStatus {
+	return UploadStatus{ID: u.id, Name: u.name, State: state, Received: u.received, Size: u.size}
+}
+
+func (m *uploadManager) countLocked(session uint64) int {
+	n := 0
+	for _, u := range m.uploads {
+		if u.session == session {
+			n++
+		}
+	}
+	return n
+}
+
+// start ouvre un envoi pour session, ou le reprend là où il s'était arrêté
+// si un fichier partiel existe déjà pour cet identifiant.
+func (m *uploadManager) start(session uint64, id, name string, size int64) (UploadStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireLocked()
+
+	clean, err := cleanUploadName(name)
+	if err != nil {
+		return UploadStatus{}, err
+	}
+	if size > m.maxSize {
+		return UploadStatus{}, fmt.Errorf("fichier trop volumineux (%d octets, max %d)", size, m.maxSize)
+	}
+
+	if u, ok := m.uploads[id]; ok {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if u.size != size {
+			return UploadStatus{}, fmt.Errorf("identifiant %s déjà utilisé pour un autre fichier", id)
+		}
+		if u.session != session && m.countLocked(session) >= uploadMaxPerSession {
+			return UploadStatus{}, fmt.Errorf("trop d'envois en cours pour cette session (max %d)", uploadMaxPerSession)
+		}
+		if u.file == nil {
+			f, err := m.root.OpenFile(uploadPartName(id), os.O_WRONLY, 0600)
+			if err != nil {
+				m.removeLocked(u)
+				return UploadStatus{}, err
+			}
+			u.file = f
+		}
+		u.session, u.name, u.updated = session, clean, time.Now()
+		return u.status("ready"), nil
+	}
+
+	if len(m.uploads) >= uploadMaxPending {
+		return UploadStatus{}, fmt.Errorf("trop d'envois en cours sur le serveur (max %d)", uploadMaxPending)
+	}
+	if m.countLocked(session) >= uploadMaxPerSession {
+		return UploadStatus{}, fmt.Errorf("trop d'envois en cours pour cette session (max %d)", uploadMaxPerSession)
+	}
+	reserve := size
+	if size < 0 {
+		reserve = m.maxSize
+	}
+	if m.reserved+reserve > m.maxPending {
+		return UploadStatus{}, fmt.Errorf("espace réservé aux envois en cours épuisé (%d octets sur %d)", m.reserved, m.maxPending)
+	}
+
+	f, err := m.root.OpenFile(uploadPartName(id), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return UploadStatus{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return UploadStatus{}, err
+	}
+	u := &pendingUpload{id: id, session: session, reserve: reserve, name: clean, size: size, received: info.Size(), file: f, updated: time.Now()}
+	if size >= 0 && u.received > size {
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return UploadStatus{}, err
+		}
+		u.received = 0
+	}
+	m.uploads[id] = u
+	m.reserved += reserve
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if size == u.received {
+		return m.finishLocked(u)
+	}
+	return u.status("ready"), nil
+}
+
+// write ajoute un morceau de session qui doit commencer à offset. L'envoi
+// est terminé dès que la taille annoncée est atteinte.
+func (m *uploadManager) write(session uint64, id string, offset int64, data []byte) (UploadStatus, error) {
+	m.mu.Lock()
+	u, ok := m.uploads[id]
+	m.mu.Unlock()
+	if !ok {
+		return UploadStatus{ID: id}, fmt.Errorf("envoi %s inconnu ou expiré", id)
+	}
+
+	u.mu.Lock()
+	if u.session != session {
+		u.mu.Unlock()
+		return UploadStatus{ID: id}, fmt.Errorf("envoi %s ouvert par une autre session", id)
+	}
+	if u.closed || u.file == nil {
+		u.mu.Unlock()
+		return UploadStatus{ID: id}, fmt.Errorf("envoi %s interrompu, à reprendre", id)
+	}
+	if offset != u.received || u.received == u.size {
+		// Morceau en double, ou envoi complet en cours de finalisation
+		defer u.mu.Unlock()
+		return u.status("ready"), errUploadOffset
+	}
+	limit := m.maxSize
+	if u.size >= 0 {
+		limit = u.size
+	}
+	if u.received+int64(len(data)) > limit {
+		status := u.status("failed")
+		u.mu.Unlock()
+		m.discard(u)
+		return status, fmt.Errorf("fichier plus gros que prévu (max %d octets)", limit)
+	}
+
+	n, err := u.file.WriteAt(data, offset)
+	u.received += int64(n)
+	u.updated = time.Now()
+	status := u.status("progress")
+	complete := u.size >= 0 && u.received == u.size
+	u.mu.Unlock()
+
+	if err != nil {
+		m.discard(u)
+		status.State = "failed"
+		return status, err
+	}
+	if complete {
+		return m.finish(id)
+	}
+	return status, nil
+}
+
+// finish termine un envoi : taille annoncée atteinte, ou fin d'un envoi
+// multipart de taille inconnue.
+func (m *uploadManager) finish(id string) (UploadStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.uploads[id]
+	if !ok {
+		return UploadStatus{ID: id}, fmt.Errorf("envoi %s inconnu ou expiré", id)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return m.finishLocked(u)
+}
+
+// finishLocked renomme le fichier partiel ; un fichier existant du même nom
+// n'est jamais écrasé. m.mu et u.mu sont tenus.
+func (m *uploadManager) finishLocked(u *pendingUpload) (UploadStatus, error) {
+	m.releaseLocked(u)
+	if u.file == nil {
+		m.root.Remove(uploadPartName(u.id))
+		return u.status("failed"), fmt.Errorf("envoi %s interrompu", u.id)
+	}
+	err := u.file.Close()
+	u.file = nil
+	if err != nil {
+		m.root.Remove(uploadPartName(u.id))
+		return u.status("failed"), err
+	}
+
+	ext := filepath.Ext(u.name)
+	base := strings.TrimSuffix(u.name, ext)
+	name := u.name
+	for i := 1; ; i++ {
+		if _, err := m.root.Lstat(name); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	if err := m.root.Rename(uploadPartName(u.id), name); err != nil {
+		m.root.Remove(uploadPartName(u.id))
+		return u.status("failed"), err
+	}
+	u.name, u.size = name, u.received
+	return u.status("done"), nil
+}
+
+// cancel abandonne un envoi de session. Le fichier partiel d'un envoi
+// inconnu (laissé par une exécution précédente) n'appartient à personne :
+// il n'est pas supprimé, mais repris par un "start" ou expiré.
+func (m *uploadManager) cancel(session uint64, id string) (UploadStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.uploads[id]
+	if !ok {
+		return UploadStatus{ID: id, State: "cancelled"}, nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.session != session {
+		return UploadStatus{ID: id}, fmt.Errorf("envoi %s ouvert par une autre session", id)
+	}
+	m.removeLocked(u)
+	return u.status("cancelled"), nil
+}
+
+// discard supprime un envoi en échec.
+func (m *uploadManager) discard(u *pendingUpload) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.closed {
+		m.removeLocked(u)
+	}
+}
+
+// detach ferme les fichiers des envois d'une session qui se déconnecte ;
+// ils restent réservés jusqu'à leur reprise ou leur expiration.
+func (m *uploadManager) detach(session uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.uploads {
+		if u.session != session {
+			continue
+		}
+		u.mu.Lock()
+		if u.file != nil {
+			u.file.Close()
+			u.file = nil
+		}
+		u.mu.Unlock()
+	}
+}
+
+// releaseLocked retire u de la liste et rend sa réservation.
+func (m *uploadManager) releaseLocked(u *pendingUpload) {
+	delete(m.uploads, u.id)
+	m.reserved -= u.reserve
+	u.closed = true
+}
+
+// removeLocked abandonne u et supprime son fichier partiel. m.mu et u.mu
+// sont tenus.
+func (m *uploadManager) removeLocked(u *pendingUpload) {
+	m.releaseLocked(u)
+	if u.file != nil {
+		u.file.Close()
+		u.file = nil
+	}
+	m.root.Remove(uploadPartName(u.id))
+}
+
+// expireLocked supprime les envois abandonnés depuis plus de uploadExpiry,
+// y compris les fichiers partiels laissés par une exécution précédente.
+func (m *uploadManager) expireLocked() {
+	deadline := time.Now().Add(-uploadExpiry)
+	for _, u := range m.uploads {
+		u.mu.Lock()
+		if u.updated.Before(deadline) {
+			log.Printf("Envoi %s (%s) abandonné, fichier partiel supprimé", u.id, u.name)
+			m.removeLocked(u)
+		}
+		u.mu.Unlock()
+	}
+
+	entries, err := fs.ReadDir(m.root.FS(), ".")
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, uploadPartPrefix) || !strings.HasSuffix(name, uploadPartSuffix) {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(name, uploadPartPrefix), uploadPartSuffix)
+		if _, ok := m.uploads[id]; ok {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(deadline) {
+			m.root.Remove(name)
+		}
+	}
+}
+
+// handleUploadEvent traite un envoi par morceaux sur le websocket. Les
+// échecs sont signalés par un statut "failed" propre à l'envoi.
+func (s *ScreenStreamer) handleUploadEvent(c *client, ev *UploadEvent) error {
+	if s.uploads == nil {
+		return &protocolError{Code: errUploadFailed, Message: "envoi de fichiers désactivé sur le serveur (-upload-dir)"}
+	}
+	// Déposer un fichier dans la VM est une prise de contrôle. Le navigateur
+	// annule ses envois avant de retirer le contrôle.
+	if !c.controlEnabled.Load() {
+		c.sendEvent("upload", UploadStatus{ID: ev.ID, State: "failed", Message: "contrôle non activé"})
+		return nil
+	}
+
+	var status UploadStatus
+	var err error
+	switch ev.Action {
+	case "start":
+		status, err = s.uploads.start(c.id, ev.ID, ev.Name, ev.Size)
+		if err == nil && status.State == "ready" {
+			log.Printf("Client %d: envoi de %s (%d octets, reprise à %d)", c.id, status.Name, status.Size, status.Received)
+		}
+	case "chunk":
+		data, _ := base64.StdEncoding.DecodeString(ev.Data)
+		status, err = s.uploads.write(c.id, ev.ID, ev.Offset, data)
+		if errors.Is(err, errUploadOffset) {
+			// Morceau déjà reçu ou perdu : le navigateur reprend à status.Received
+			err = nil
+		}
+	case "cancel":
+		status, err = s.uploads.cancel(c.id, ev.ID)
+	}
+
+	if err != nil {
+		log.Printf("Client %d: échec de l'envoi %s: %v", c.id, ev.ID, err)
+		status.ID, status.State, status.Message = ev.ID, "failed", err.Error()
+	} else if status.State == "done" {
+		log.Printf("Client %d: %s reçu (%d octets)", c.id, status.Name, status.Size)
+	}
+	c.sendEvent("upload", status)
+	return nil
+}
+
+// handleUpload reçoit des fichiers en multipart/form-data. ?session=<id>
+// (donné par le hello) doit désigner une session websocket connectée avec
+// le contrôle activé ; la progression est envoyée sur ce websocket.
+func (s *ScreenStreamer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if s.uploads == nil {
+		http.Error(w, "envoi de fichiers désactivé (-upload-dir)", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "méthode non autorisée", http.StatusMethodNotAllowed)
+		return
+	}
+	// Un formulaire d'un autre site ne doit pas pouvoir déposer de fichiers
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "origine refusée", http.StatusForbidden)
+			return
+		}
+	}
+	var session *client
+	if id, err := strconv.ParseUint(r.URL.Query().Get("session"), 10, 64); err == nil {
+		session = s.clients.find(id)
+	}
+	if session == nil || !session.controlEnabled.Load() {
+		http.Error(w, "session websocket avec contrôle activé requise (?session=<id>)", http.StatusForbidden)
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("multipart attendu: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	results := []UploadStatus{}
+	buf := make([]byte, 256<<10)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("lecture multipart: %v", err), http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		status, err := s.receivePart(session, part, buf)
+		if err != nil {
+			log.Printf("Échec de l'envoi multipart de %q: %v", part.FileName(), err)
+			status.State, status.Message = "failed", err.Error()
+		} else {
+			log.Printf("%s reçu (%d octets)", status.Name, status.Size)
+		}
+		session.sendEvent("upload", status)
+		results = append(results, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// receivePart écrit un fichier du formulaire sous un identifiant tiré au
+// hasard : un envoi multipart interrompu ne se reprend pas.
+func (s *ScreenStreamer) receivePart(session *client, part *multipart.Part, buf []byte) (UploadStatus, error) {
+	var raw [16]byte
+	rand.Read(raw[:])
+	id := hex.EncodeToString(raw[:])
+
+	status, err := s.uploads.start(session.id, id, part.FileName(), -1)
+	if err != nil {
+		return status, err
+	}
+	var lastProgress time.Time
+	for {
+		n, readErr := part.Read(buf)
+		if n > 0 {
+			if status, err = s.uploads.write(session.id, id, status.Received, buf[:n]); err != nil {
+				s.uploads.cancel(session.id, id)
+				return status, err
+			}
+			if time.Since(lastProgress) >= uploadProgressInterval {
+				lastProgress = time.Now()
+				session.sendEvent("upload", status)
+			}
+		}
+		if readErr == io.EOF {
+			return s.uploads.finish(id)
+		}
+		if readErr != nil {
+			s.uploads.cancel(session.id, id)
+			return status, readErr
+		}
+	}
+}
+
+func (s *ScreenStreamer) uploadMax() int64 {
+	if s.uploads == nil {
+		return 0
+	}
+	return s.uploads.maxSize
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testUploads(t *testing.T, maxSize int64) (*uploadManager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	m, err := newUploadManager(dir, maxSize, 4*maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.root.Close() })
+	return m, dir
+}
+
+func readUpload(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCleanUploadName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"rapport.pdf", "rapport.pdf", false},
+		{"  espace.txt ", "espace.txt", false},
+		{"../../etc/passwd", "passwd", false},
+		{"/etc/shadow", "shadow", false},
+		{`C:\Users\moi\notes.txt`, "notes.txt", false},
+		{`..\..\win.ini`, "win.ini", false},
+		{"dossier/sous/fichier.txt", "fichier.txt", false},
+		{"a\x00b\nc.txt", "abc.txt", false},
+		{"", "", true},
+		{"   ", "", true},
+		{".", "", true},
+		{"..", "", true},
+		{"/", "", true},
+		{"dossier/..", "", true},
+		{`\`, "", true},
+		{"\x00\x01", "", true},
+		{".upload-abcdefgh.part", "", true},
+		{strings.Repeat("a", 255), strings.Repeat("a", 255), false},
+		{strings.Repeat("a", 256), "", true},
+	}
+	for _, tt := range tests {
+		got, err := cleanUploadName(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("cleanUploadName(%q) = %q, %v ; attendu %q (erreur: %v)", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// Les noms qui tentent de sortir du dossier y restent, et un lien
+// symbolique vers l'extérieur n'est jamais suivi.
+func TestUploadConfinement(t *testing.T) {
+	m, dir := testUploads(t, 100)
+	outside := t.TempDir()
+
+	for i, name := range []string{"../evasion.txt", "/tmp/evasion.txt", `..\evasion.txt`} {
+		id := "confine" + string(rune('a'+i))
+		if _, err := m.start(1, id, name, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.write(1, id, 0, []byte("ok")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("%d fichier(s) dans le dossier, attendu 3", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "evasion.txt")); err == nil {
+		t.Error("fichier écrit hors du dossier")
+	}
+
+	// Lien à la place du fichier final : renommé à côté, cible intacte
+	target := filepath.Join(outside, "cible.txt")
+	os.WriteFile(target, []byte("intact"), 0600)
+	if err := os.Symlink(target, filepath.Join(dir, "lien.txt")); err != nil {
+		t.Skip("liens symboliques indisponibles:", err)
+	}
+	m.start(1, "linkname", "lien.txt", 3)
+	status, err := m.write(1, "linkname", 0, []byte("abc"))
+	if err != nil || status.Name != "lien (1).txt" {
+		t.Errorf("envoi sur un lien: %+v, %v", status, err)
+	}
+
+	// Lien à la place du fichier partiel : refusé par os.Root
+	os.Symlink(target, filepath.Join(dir, uploadPartName("linkpart")))
+	if _, err := m.start(1, "linkpart", "x.txt", 3); err == nil {
+		t.Error("fichier partiel ouvert à travers un lien vers l'extérieur")
+	}
+	if got := readUpload(t, outside, "cible.txt"); got != "intact" {
+		t.Errorf("cible modifiée: %q", got)
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	m, dir := testUploads(t, 100)
+
+	if _, err := m.start(1, "resumeid", "a.txt", 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.write(1, "resumeid", 0, []byte("0123")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Morceau qui ne suit pas le dernier reçu
+	status, err := m.write(1, "resumeid", 2, []byte("xx"))
+	if !errors.Is(err, errUploadOffset) || status.Received != 4 {
+		t.Errorf("offset décalé: %+v, %v ; attendu errUploadOffset à 4", status, err)
+	}
+
+	// Déconnexion : le fichier est fermé, l'écriture refusée jusqu'à la reprise
+	m.detach(1)
+	if _, err := m.write(1, "resumeid", 4, []byte("45")); err == nil {
+		t.Error("écriture acceptée sur un envoi détaché")
+	}
+	status, err = m.start(2, "resumeid", "a.txt", 10)
+	if err != nil || status.State != "ready" || status.Received != 4 {
+		t.Fatalf("reprise: %+v, %v", status, err)
+	}
+	if _, err := m.write(1, "resumeid", 4, []byte("45")); err == nil {
+		t.Error("écriture acceptée depuis l'ancienne session")
+	}
+	if _, err := m.cancel(1, "resumeid"); err == nil {
+		t.Error("annulation acceptée depuis l'ancienne session")
+	}
+	status, err = m.write(2, "resumeid", 4, []byte("456789"))
+	if err != nil || status.State != "done" {
+		t.Fatalf("fin: %+v, %v", status, err)
+	}
+	if got := readUpload(t, dir, "a.txt"); got != "0123456789" {
+		t.Errorf("contenu %q", got)
+	}
+}
+
+// Après un redémarrage, le fichier partiel donne l'offset de reprise.
+func TestUploadResumeAfterRestart(t *testing.T) {
+	m, dir := testUploads(t, 100)
+	m.start(1, "restartid", "b.txt", 6)
+	m.write(1, "restartid", 0, []byte("abc"))
+	m.detach(1)
+
+	m2, err := newUploadManager(dir, 100, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m2.root.Close()
+
+	// Annuler un envoi inconnu ne supprime pas un fichier partiel sans propriétaire
+	if _, err := m2.cancel(7, "restartid"); err != nil {
+		t.Fatal(err)
+	}
+	status, err := m2.start(3, "restartid", "b.txt", 6)
+	if err != nil || status.Received != 3 {
+		t.Fatalf("reprise: %+v, %v ; attendu 3 octets reçus", status, err)
+	}
+	if status, err = m2.write(3, "restartid", 3, []byte("def")); err != nil || status.State != "done" {
+		t.Fatalf("fin: %+v, %v", status, err)
+	}
+	if got := readUpload(t, dir, "b.txt"); got != "abcdef" {
+		t.Errorf("contenu %q", got)
+	}
+}
+
+func TestUploadMaxSize(t *testing.T) {
+	m, dir := testUploads(t, 8)
+
+	if _, err := m.start(1, "toolarge", "c.txt", 9); err == nil {
+		t.Error("taille annoncée au-delà du maximum acceptée")
+	}
+
+	// Plus d'octets que la taille annoncée
+	m.start(1, "overflow", "d.txt", 4)
+	if _, err := m.write(1, "overflow", 0, []byte("12345")); err == nil {
+		t.Error("morceau au-delà de la taille annoncée accepté")
+	}
+
+	// Taille inconnue (multipart) : bornée par le maximum
+	m.start(1, "multipart", "e.txt", -1)
+	if _, err := m.write(1, "multipart", 0, []byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.write(1, "multipart", 8, []byte("9")); err == nil {
+		t.Error("envoi multipart au-delà du maximum accepté")
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d fichier(s) restant après les échecs", len(entries))
+	}
+	if m.reserved != 0 {
+		t.Errorf("%d octets encore réservés", m.reserved)
+	}
+}
+
+func TestUploadExpiry(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * uploadExpiry)
+
+	// Fichier partiel d'une exécution précédente
+	stale := filepath.Join(dir, uploadPartName("staleidx"))
+	os.WriteFile(stale, []byte("vieux"), 0600)
+	os.Chtimes(stale, old, old)
+	recent := filepath.Join(dir, uploadPartName("recentid"))
+	os.WriteFile(recent, []byte("récent"), 0600)
+
+	m, err := newUploadManager(dir, 100, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.root.Close()
+	if _, err := os.Stat(stale); err == nil {
+		t.Error("fichier partiel périmé conservé")
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Error("fichier partiel récent supprimé")
+	}
+
+	// Envoi en mémoire sans nouvelles : supprimé au prochain start
+	m.start(1, "idleupld", "f.txt", 10)
+	m.write(1, "idleupld", 0, []byte("abc"))
+	m.uploads["idleupld"].updated = old
+	m.start(2, "otherupl", "g.txt", 10)
+	if _, ok := m.uploads["idleupld"]; ok {
+		t.Error("envoi inactif conservé")
+	}
+	if _, err := os.Stat(filepath.Join(dir, uploadPartName("idleupld"))); err == nil {
+		t.Error("fichier partiel de l'envoi inactif conservé")
+	}
+	if m.reserved != 10 {
+		t.Errorf("%d octets réservés, attendu 10", m.reserved)
+	}
+}